@@ -0,0 +1,252 @@
+package azure_monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/dimchansky/utfbom"
+)
+
+const (
+	authMethodEnv = "env"
+	authMethodMSI = "msi"
+)
+
+// The Azure clouds metrics can be gathered from, selecting the resource
+// manager and Active Directory endpoints.
+var clouds = map[string]azure.Environment{
+	"AzurePublic":       azure.PublicCloud,
+	"AzureUSGovernment": azure.USGovernmentCloud,
+	"AzureChina":        azure.ChinaCloud,
+	"AzureGermany":      azure.GermanCloud,
+}
+
+// validateCredentials checks that the configured credentials are complete
+// for the selected authentication method.
+func (a *AzureMonitor) validateCredentials() error {
+	switch a.AuthMethod {
+	case authMethodEnv:
+		if a.ClientSecret != "" && a.ClientCertPath != "" {
+			return errors.New("client_secret and client_cert_path cannot be used together")
+		}
+		if a.ClientCertPassword != "" && a.ClientCertPath == "" {
+			return errors.New("client_cert_password requires client_cert_path")
+		}
+
+		secret, option := a.ClientSecret, "client_secret"
+		if a.ClientCertPath != "" {
+			secret, option = a.ClientCertPath, "client_cert_path"
+		}
+		credentials := 0
+		for _, v := range []string{a.ClientID, secret, a.TenantID} {
+			if v != "" {
+				credentials++
+			}
+		}
+		if credentials != 0 && credentials != 3 {
+			return fmt.Errorf("client_id, %s and tenant_id must be configured together", option)
+		}
+		if credentials != 0 && a.AuthFile != "" {
+			return fmt.Errorf("auth_file cannot be used together with client_id, %s and tenant_id", option)
+		}
+
+		if a.ClientCertPath != "" {
+			f, err := os.Open(a.ClientCertPath)
+			if err != nil {
+				return fmt.Errorf("error reading client_cert_path: %v", err)
+			}
+			f.Close()
+		}
+	case authMethodMSI:
+		if a.ClientSecret != "" || a.TenantID != "" {
+			return errors.New("client_secret and tenant_id are not used with msi authentication")
+		}
+		if a.ClientCertPath != "" {
+			return errors.New("client_cert_path is not used with msi authentication")
+		}
+		if a.AuthFile != "" {
+			return errors.New("auth_file is not used with msi authentication")
+		}
+	default:
+		return fmt.Errorf("unknown auth_method %q, must be %q or %q", a.AuthMethod, authMethodEnv, authMethodMSI)
+	}
+	return nil
+}
+
+// tokenSource provides the access tokens requests are signed with, as
+// implemented by adal.ServicePrincipalToken.
+type tokenSource interface {
+	Token() adal.Token
+	Refresh() error
+}
+
+// newAuthorizer creates the authorizer used to sign requests for the
+// configured cloud.
+func (a *AzureMonitor) newAuthorizer() (autorest.Authorizer, error) {
+	source, err := a.newTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenAuthorizer{
+		source:        source,
+		refreshBuffer: time.Duration(a.TokenRefreshBuffer),
+		timeFunc:      a.timeFunc,
+	}, nil
+}
+
+// newTokenSource creates the token source for the configured authentication
+// method. With the env method, a client secret or certificate of a service
+// principal from the configuration or the auth file take precedence over the
+// environment, which is searched in the same order as
+// auth.EnvironmentSettings.GetAuthorizer.
+func (a *AzureMonitor) newTokenSource() (tokenSource, error) {
+	if a.AuthMethod == authMethodMSI {
+		config := auth.NewMSIConfig()
+		config.Resource = a.TokenAudience
+		config.ClientID = a.ClientID
+		return config.ServicePrincipalToken()
+	}
+
+	if a.ClientID != "" && a.ClientSecret != "" && a.TenantID != "" {
+		config := auth.NewClientCredentialsConfig(a.ClientID, a.ClientSecret, a.TenantID)
+		config.AADEndpoint = a.environment.ActiveDirectoryEndpoint
+		config.Resource = a.TokenAudience
+		return config.ServicePrincipalToken()
+	}
+
+	if a.ClientID != "" && a.ClientCertPath != "" && a.TenantID != "" {
+		config := auth.NewClientCertificateConfig(a.ClientCertPath, a.ClientCertPassword, a.ClientID, a.TenantID)
+		config.AADEndpoint = a.environment.ActiveDirectoryEndpoint
+		config.Resource = a.TokenAudience
+		return config.ServicePrincipalToken()
+	}
+
+	if a.AuthFile != "" {
+		return a.newFileTokenSource()
+	}
+
+	settings, err := auth.GetSettingsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	settings.Environment = a.environment
+	settings.Values[auth.Resource] = a.TokenAudience
+
+	if config, err := settings.GetClientCredentials(); err == nil {
+		return config.ServicePrincipalToken()
+	}
+	if config, err := settings.GetClientCertificate(); err == nil {
+		return config.ServicePrincipalToken()
+	}
+	if config, err := settings.GetUsernamePassword(); err == nil {
+		return config.ServicePrincipalToken()
+	}
+	return settings.GetMSI().ServicePrincipalToken()
+}
+
+// authFile is the service principal auth file written by the Azure CLI with
+// "az ad sp create-for-rbac --sdk-auth".
+type authFile struct {
+	ClientID                  string `json:"clientId"`
+	ClientSecret              string `json:"clientSecret"`
+	ClientCertificate         string `json:"clientCertificate"`
+	ClientCertificatePassword string `json:"clientCertificatePassword"`
+	TenantID                  string `json:"tenantId"`
+	ActiveDirectoryEndpoint   string `json:"activeDirectoryEndpointUrl"`
+}
+
+// newFileTokenSource creates a token source from the credentials in the
+// auth file, trying client credentials before the client certificate like
+// auth.NewAuthorizerFromFileWithResource. The file is read directly as the
+// SDK only looks it up through the AZURE_AUTH_LOCATION environment variable.
+func (a *AzureMonitor) newFileTokenSource() (tokenSource, error) {
+	f, err := os.Open(a.AuthFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file authFile
+	if err := json.NewDecoder(utfbom.SkipOnly(f)).Decode(&file); err != nil {
+		return nil, fmt.Errorf("error parsing auth file %q: %v", a.AuthFile, err)
+	}
+
+	settings := auth.FileSettings{Values: map[string]string{}}
+	for key, value := range map[string]string{
+		auth.ClientID:                file.ClientID,
+		auth.ClientSecret:            file.ClientSecret,
+		auth.CertificatePath:         file.ClientCertificate,
+		auth.CertificatePassword:     file.ClientCertificatePassword,
+		auth.TenantID:                file.TenantID,
+		auth.ActiveDirectoryEndpoint: file.ActiveDirectoryEndpoint,
+	} {
+		if value != "" {
+			settings.Values[key] = value
+		}
+	}
+	if file.ActiveDirectoryEndpoint == "" {
+		settings.Values[auth.ActiveDirectoryEndpoint] = a.environment.ActiveDirectoryEndpoint
+	}
+
+	resource := a.TokenAudience
+	if token, err := settings.ServicePrincipalTokenFromClientCredentialsWithResource(resource); err == nil {
+		return token, nil
+	}
+	if token, err := settings.ServicePrincipalTokenFromClientCertificateWithResource(resource); err == nil {
+		return token, nil
+	}
+	return nil, fmt.Errorf("auth file %q is missing client and certificate credentials", a.AuthFile)
+}
+
+// tokenAuthorizer signs requests with a cached bearer token, which is only
+// refreshed once it expires within the refresh buffer.
+type tokenAuthorizer struct {
+	source        tokenSource
+	refreshBuffer time.Duration
+	timeFunc      func() time.Time
+
+	mu sync.Mutex
+}
+
+// WithAuthorization returns a PrepareDecorator adding the bearer token to
+// the Authorization header of requests.
+func (t *tokenAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			token, err := t.token()
+			if err != nil {
+				return r, err
+			}
+			return autorest.Prepare(r, autorest.WithBearerAuthorization(token))
+		})
+	}
+}
+
+// token returns the cached access token, refreshing it first if it is
+// missing or about to expire.
+func (t *tokenAuthorizer) token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token := t.source.Token()
+	if token.AccessToken == "" || !token.Expires().After(t.timeFunc().Add(t.refreshBuffer)) {
+		if err := t.source.Refresh(); err != nil {
+			return "", fmt.Errorf("error refreshing token: %v", err)
+		}
+		token = t.source.Token()
+	}
+	return token.AccessToken, nil
+}
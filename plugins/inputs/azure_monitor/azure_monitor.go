@@ -0,0 +1,2355 @@
+package azure_monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/filter"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/proxy"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultCloudName        = "AzurePublic"
+	defaultMeasurementName  = "azure_monitor"
+	costMeasurementName     = "azure_monitor_cost"
+	internalMeasurementName = "azure_monitor_internal"
+	metaMeasurementName     = "azure_monitor_meta"
+	upMeasurementName       = "azure_monitor_up"
+	errorsMeasurementName   = "azure_monitor_errors"
+	defaultAPIVersion       = "2018-01-01"
+	defaultTimespan         = time.Minute
+	defaultTimeout          = 5 * time.Second
+	defaultMaxRetries       = 3
+	defaultMaxConcurrency   = 4
+	defaultRetryInterval    = time.Second
+	defaultRefreshBuffer    = 5 * time.Minute
+	defaultBreakerCooldown  = 5 * time.Minute
+	defaultMaxResponseSize  = 32 * 1024 * 1024
+	defaultFieldSeparator   = "_"
+	defaultMaxPages         = 10
+	defaultDedupeCacheSize  = 10000
+	defaultMaxIdleConns     = 100
+	defaultMaxIdleConnsHost = 10
+	maxRetryInterval        = 30 * time.Second
+	maxValuePrecision       = 15
+)
+
+// The policies for time series without any data points.
+const (
+	emptySeriesSkip = "skip"
+	emptySeriesZero = "zero"
+	emptySeriesWarn = "warn"
+)
+
+// The policies for metrics reported more than once in a response.
+const (
+	duplicateOverwrite = "overwrite"
+	duplicatePrefix    = "prefix"
+	duplicateError     = "error"
+)
+
+var (
+	apiVersionRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	namespaceRe  = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	durationRe   = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+	resourceIDRe = regexp.MustCompile(`(?i)^/subscriptions/[0-9a-f]{8}(?:-[0-9a-f]{4}){3}-[0-9a-f]{12}/resourceGroups/[^/]+/providers/[^/]+(?:/[^/]+/[^/]+)+$`)
+)
+
+// The leading segments every resource ID starts with, in order, followed by
+// one or more pairs of resource type and name.
+var resourceIDSegments = []struct {
+	re          *regexp.Regexp
+	description string
+}{
+	{regexp.MustCompile(`(?i)^subscriptions$`), `"subscriptions"`},
+	{regexp.MustCompile(`(?i)^[0-9a-f]{8}(?:-[0-9a-f]{4}){3}-[0-9a-f]{12}$`), "a subscription GUID"},
+	{regexp.MustCompile(`(?i)^resourceGroups$`), `"resourceGroups"`},
+	{regexp.MustCompile(`^.+$`), "a resource group name"},
+	{regexp.MustCompile(`(?i)^providers$`), `"providers"`},
+	{regexp.MustCompile(`^.+$`), "a resource provider namespace"},
+}
+
+// The aggregation types supported by the Azure Monitor metrics API.
+var supportedAggregations = []string{"Average", "Total", "Minimum", "Maximum", "Count"}
+
+// The fields of AzureMonitorResponseValue response_field_tags may list.
+var supportedResponseFieldTags = []string{"type", "unit", "id", "display_description"}
+
+// AzureMonitor gathers metrics of Azure resources from the Azure Monitor
+// metrics API
+type AzureMonitor struct {
+	ResourceId         string   `toml:"resource_id"`
+	FallbackResourceId string   `toml:"fallback_resource_id"`
+	ResourceIds        []string `toml:"resource_ids"`
+	ResourceIdFile     string   `toml:"resource_id_file"`
+	Metrics            []string `toml:"metrics"`
+	MetricInclude      []string `toml:"metric_include"`
+	MetricExclude      []string `toml:"metric_exclude"`
+	Filter             string   `toml:"filter"`
+	Top                int      `toml:"top"`
+	OrderBy            string   `toml:"order_by"`
+	Namespace          string   `toml:"namespace"`
+	Aggregations       []string `toml:"aggregations"`
+	ApiVersion         string   `toml:"api_version"`
+	Timespan           string   `toml:"timespan"`
+	StartTime          string   `toml:"start_time"`
+	EndTime            string   `toml:"end_time"`
+	Interval           string   `toml:"aggregation_interval"`
+
+	TimestampShift config.Duration `toml:"timestamp_shift"`
+
+	CountAsInteger       bool `toml:"count_as_integer"`
+	AutoAdjustTimegrain  bool `toml:"auto_adjust_timegrain"`
+	ValidateDimensions   bool `toml:"validate_dimensions"`
+	ValidateAggregations bool `toml:"validate_aggregations"`
+
+	MeasurementName      string `toml:"measurement_name"`
+	MetricPerMeasurement bool   `toml:"metric_per_measurement"`
+	DimensionsAsSuffix   bool   `toml:"dimensions_as_field_suffix"`
+	LatestOnly           bool   `toml:"latest_only"`
+	WideRows             bool   `toml:"wide_rows"`
+	EmptySeriesPolicy    string `toml:"empty_series_policy"`
+	Dedupe               bool   `toml:"dedupe"`
+	DedupeCacheSize      int    `toml:"dedupe_cache_size"`
+	ReportCost           bool   `toml:"report_cost"`
+	ReportGatherDuration bool   `toml:"report_gather_duration"`
+	ReportRateLimit      bool   `toml:"report_ratelimit"`
+	EmitStatusCode       bool   `toml:"emit_status_code"`
+	ReportUp             bool   `toml:"report_up"`
+	TagErrorCode         bool   `toml:"tag_error_code"`
+	EmitErrorCode        bool   `toml:"emit_error_code"`
+	ErrorOnEmpty         bool   `toml:"error_on_empty"`
+	EmitMetadata         bool   `toml:"emit_metadata"`
+	IncludeResourceIDTag bool   `toml:"include_resource_id_tag"`
+	IncludeGroupTag      bool   `toml:"include_resource_group_tag"`
+	IncludeNamespaceTag  bool   `toml:"include_namespace_tag"`
+	IncludeRegionTag     bool   `toml:"include_region_tag"`
+	IMDSRegionFallback   bool   `toml:"imds_region_fallback"`
+	IncludeIntervalTag   bool   `toml:"include_interval_tag"`
+	IncludeTimespanTag   bool   `toml:"include_timespan_tag"`
+	IncludeUnitTag       bool   `toml:"include_unit_tag"`
+	IncludeMetricIDTag   bool   `toml:"include_metric_id_tag"`
+	UseLocalizedNames    bool   `toml:"use_localized_names"`
+	NormalizeFieldNames  bool   `toml:"normalize_field_names"`
+	FieldPrefix          string `toml:"field_prefix"`
+	FieldSeparator       string `toml:"field_separator"`
+	AggregationAsTag     bool   `toml:"aggregation_as_tag"`
+	DuplicatePolicy      string `toml:"duplicate_policy"`
+
+	IncludeSubscriptionTag bool `toml:"include_subscription_tag"`
+	IncludeResourceNameTag bool `toml:"include_resource_name_tag"`
+
+	ResponseFieldTags []string `toml:"response_field_tags"`
+
+	ValueScale     float64            `toml:"value_scale"`
+	ValuePrecision int                `toml:"value_precision"`
+	MetricScale    map[string]float64 `toml:"metric_scale"`
+	FieldNameMap   map[string]string  `toml:"field_name_map"`
+
+	CloudName           string          `toml:"cloud_name"`
+	ManagementEndpoint  string          `toml:"management_endpoint"`
+	Timeout             config.Duration `toml:"timeout"`
+	MaxRetries          int             `toml:"max_retries"`
+	MaxResponseBodySize config.Size     `toml:"max_response_body_size"`
+	MaxPages            int             `toml:"max_pages"`
+	UserAgent           string          `toml:"user_agent"`
+	EnableGzip          bool            `toml:"enable_gzip"`
+
+	MaxConcurrentRequests int     `toml:"max_concurrent_requests"`
+	RequestsPerSecond     float64 `toml:"requests_per_second"`
+	MaxIdleConns          int     `toml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int     `toml:"max_idle_conns_per_host"`
+
+	CircuitBreakerThreshold int             `toml:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  config.Duration `toml:"circuit_breaker_cooldown"`
+	ServeStaleFor           config.Duration `toml:"serve_stale_for"`
+
+	proxy.HTTPProxy
+	tls.ClientConfig
+
+	AuthMethod         string `toml:"auth_method"`
+	ClientID           string `toml:"client_id"`
+	ClientSecret       string `toml:"client_secret"`
+	ClientCertPath     string `toml:"client_cert_path"`
+	ClientCertPassword string `toml:"client_cert_password"`
+	TenantID           string `toml:"tenant_id"`
+	AuthFile           string `toml:"auth_file"`
+	SubscriptionID     string `toml:"subscription_id"`
+
+	TokenAudience      string          `toml:"token_audience"`
+	TokenRefreshBuffer config.Duration `toml:"token_refresh_buffer"`
+
+	Resources []ResourceConfig `toml:"resource"`
+
+	Log telegraf.Logger `toml:"-"`
+
+	resourceIDs      []string
+	resourceFile     *resourceIDFile
+	resources        map[string]resourceSettings
+	metricFilter     filter.Filter
+	timespanDuration time.Duration
+	environment      azure.Environment
+	baseURL          string
+	authorizer       autorest.Authorizer
+	client           *http.Client
+	retryInterval    time.Duration
+	limiter          *rate.Limiter
+	breaker          *circuitBreaker
+	dedupe           *dedupeCache
+	stale            *staleCache
+	imdsURL          string
+	imdsOnce         sync.Once
+	imdsRegion       string
+	successMu        sync.Mutex
+	lastSuccess      map[string]time.Time
+	ctx              context.Context
+	cancel           context.CancelFunc
+	timeFunc         func() time.Time
+}
+
+// ResourceConfig configures a resource with its own metrics and
+// aggregations; the top-level options are used for those left empty.
+type ResourceConfig struct {
+	ResourceID   string   `toml:"resource_id"`
+	Metrics      []string `toml:"metrics"`
+	Aggregations []string `toml:"aggregations"`
+}
+
+// resourceSettings are the metrics and aggregations requested for a resource
+type resourceSettings struct {
+	metrics      []string
+	aggregations []string
+}
+
+// AzureMonitorResponse is the body returned by the metrics API
+type AzureMonitorResponse struct {
+	Cost           int                         `json:"cost"`
+	Timespan       string                      `json:"timespan"`
+	Interval       string                      `json:"interval"`
+	Value          []AzureMonitorResponseValue `json:"value"`
+	Namespace      string                      `json:"namespace"`
+	ResourceRegion string                      `json:"resourceregion"`
+	NextLink       string                      `json:"nextLink"`
+}
+
+// AzureMonitorResponseValue holds the time series of a single metric
+type AzureMonitorResponseValue struct {
+	Id                 string                           `json:"id"`
+	Type               string                           `json:"type"`
+	Name               AzureMonitorResponseValueName    `json:"name"`
+	DisplayDescription string                           `json:"displayDescription"`
+	Unit               string                           `json:"unit"`
+	Timeseries         []AzureMonitorResponseTimeSeries `json:"timeseries"`
+	ErrorCode          string                           `json:"errorCode"`
+}
+
+// field returns the value of the field response_field_tags refers to by name
+func (v *AzureMonitorResponseValue) field(name string) string {
+	switch name {
+	case "type":
+		return v.Type
+	case "unit":
+		return v.Unit
+	case "id":
+		return v.Id
+	case "display_description":
+		return v.DisplayDescription
+	}
+	return ""
+}
+
+// AzureMonitorResponseValueName is the name of a metric or dimension
+type AzureMonitorResponseValueName struct {
+	Value          string `json:"value"`
+	LocalizedValue string `json:"localizedValue"`
+}
+
+// AzureMonitorResponseTimeSeries is a single time series of a metric
+type AzureMonitorResponseTimeSeries struct {
+	MetadataValues []AzureMonitorResponseMetadataValue   `json:"metadatavalues"`
+	Data           []AzureMonitorResponseTimeSeriesDatum `json:"data"`
+}
+
+// AzureMonitorResponseMetadataValue is a dimension value of a time series
+type AzureMonitorResponseMetadataValue struct {
+	Name  AzureMonitorResponseValueName `json:"name"`
+	Value string                        `json:"value"`
+}
+
+// AzureMonitorResponseTimeSeriesDatum is a data point of a time series
+type AzureMonitorResponseTimeSeriesDatum struct {
+	TimeStamp string   `json:"timeStamp"`
+	Average   *float64 `json:"average"`
+	Total     *float64 `json:"total"`
+	Minimum   *float64 `json:"minimum"`
+	Maximum   *float64 `json:"maximum"`
+	Count     *float64 `json:"count"`
+}
+
+// AzureMonitorError is returned when the metrics API responds with a non-2xx
+// status code. URL is the requested URL with any credentials redacted.
+type AzureMonitorError struct {
+	StatusCode int
+	Body       string
+	ResourceID string
+	URL        string
+}
+
+func (e *AzureMonitorError) Error() string {
+	return fmt.Sprintf("azure monitor responded with status %d for resource %q (%s): %s",
+		e.StatusCode, e.ResourceID, e.URL, e.Body)
+}
+
+// AzureMonitorEmptyError is returned when the metrics API returns no metrics
+// for a resource, e.g. because the metrics option matched none of them
+type AzureMonitorEmptyError struct {
+	ResourceID string
+}
+
+func (e *AzureMonitorEmptyError) Error() string {
+	return fmt.Sprintf("azure monitor returned no metrics for resource %q", e.ResourceID)
+}
+
+// AzureMonitorTruncatedError is returned when the response body of the
+// metrics API ends before the JSON document is complete, e.g. because the
+// connection was interrupted. BytesRead is the size of the body received.
+type AzureMonitorTruncatedError struct {
+	ResourceID string
+	URL        string
+	BytesRead  int64
+	Err        error
+}
+
+func (e *AzureMonitorTruncatedError) Error() string {
+	return fmt.Sprintf("response for resource %q (%s) was truncated after %d bytes: %v",
+		e.ResourceID, e.URL, e.BytesRead, e.Err)
+}
+
+func (e *AzureMonitorTruncatedError) Unwrap() error {
+	return e.Err
+}
+
+// AzureMonitorAuthError is returned when a request cannot be authorized,
+// e.g. because Azure AD rejects the credentials. It is not retried.
+type AzureMonitorAuthError struct {
+	Err error
+}
+
+func (e *AzureMonitorAuthError) Error() string {
+	return fmt.Sprintf("error authorizing request: %v", e.Err)
+}
+
+func (e *AzureMonitorAuthError) Unwrap() error {
+	return e.Err
+}
+
+var sampleConfig = `
+  ## The ID of the Azure resource to gather metrics from, e.g.
+  ##   resource_id = "/subscriptions/<subscription_id>/resourceGroups/<resource_group>/providers/Microsoft.Storage/storageAccounts/<account>"
+  resource_id = ""
+
+  ## Resource to gather the metrics from instead when gathering those of
+  ## resource_id fails, e.g. the secondary of a geo-redundant pair. Its points
+  ## are tagged with its resource_id and failover=true.
+  # fallback_resource_id = ""
+
+  ## Additional resources to gather metrics from. The metrics of each resource
+  ## are tagged with its resource_id.
+  # resource_ids = []
+
+  ## File listing additional resource IDs, one per line; blank lines and lines
+  ## starting with '#' are ignored. The file is read again when it changes, so
+  ## resources can be added without reloading Telegraf.
+  # resource_id_file = ""
+
+  ## Names of the metrics to gather; all metrics of the resource are gathered
+  ## if empty, e.g.
+  ##   metrics = ["BlobCapacity", "Transactions"]
+  # metrics = []
+
+  ## Glob patterns of the metrics to keep and to drop from the response, e.g.
+  ## to keep a family of metrics not known in advance. Metrics matching both
+  ## are dropped.
+  ##   metric_include = ["Transactions*"]
+  # metric_include = []
+  # metric_exclude = []
+
+  ## OData filter restricting the time series returned for the dimensions of
+  ## the metrics, e.g.
+  ##   filter = "ApiName eq 'GetBlob' or ApiName eq 'PutBlob'"
+  # filter = ""
+
+  ## Maximum number of time series to return for metrics split by dimension;
+  ## Azure returns 10 if unset.
+  # top = 0
+
+  ## Order in which time series are selected when limited by top, given as
+  ## one of the requested aggregations followed by "asc" or "desc", e.g.
+  ##   order_by = "Total desc"
+  # order_by = ""
+
+  ## Metric namespace to gather metrics from; the default namespace of the
+  ## resource is used if empty. Custom namespaces, such as the guest OS
+  ## metrics of a virtual machine, must be selected explicitly, e.g.
+  ##   namespace = "azure.vm.windows.guest"
+  # namespace = ""
+
+  ## Aggregations to request for each metric. Supported values are
+  ## "Average", "Total", "Minimum", "Maximum" and "Count". Unless only
+  ## "Average" is requested, field names are suffixed with the lowercase
+  ## aggregation, e.g. "BlobCapacity_total". All aggregations of a data point
+  ## are fields of the same point, so that e.g. the average can be weighted by
+  ## the count of samples.
+  # aggregations = ["Average"]
+
+  ## Write the Count aggregation as an integer field, as it is a number of
+  ## samples although Azure reports it as a float. Ignored if
+  ## aggregation_as_tag is enabled, as the count then shares its field with
+  ## the other aggregations.
+  # count_as_integer = true
+
+  ## Version of the metrics API to use, in YYYY-MM-DD form.
+  # api_version = "2018-01-01"
+
+  ## Name of the measurement the metrics are written to.
+  # measurement_name = "azure_monitor"
+
+  ## Write each metric to its own measurement, named after the measurement
+  ## name and the metric, e.g. "azure_monitor_BlobCapacity", with a single
+  ## "value" field instead of one field per metric.
+  # metric_per_measurement = false
+
+  ## Append the dimension values of a metric to its field name, ordered by
+  ## the dimension names, instead of adding them as tags, e.g.
+  ## "Transactions_GetBlob" rather than "Transactions" tagged "ApiName=GetBlob".
+  # dimensions_as_field_suffix = false
+
+  ## Only emit the most recent data point with a value of each time series
+  ## instead of all data points in the timespan.
+  # latest_only = false
+
+  ## Combine the latest values of all metrics of a resource into a single
+  ## point timestamped with the time of the collection, instead of using the
+  ## timestamps of the data points. Metrics split by dimension still result in
+  ## one point per combination of dimension values. Requires latest_only.
+  # wide_rows = false
+
+  ## What to do with time series without any data points: "skip" them, emit
+  ## a "zero" value for each aggregation timestamped with the time of the
+  ## collection, or "warn" about and skip them.
+  # empty_series_policy = "skip"
+
+  ## Skip points that were already emitted with the same values by a previous
+  ## collection, as the timespans of consecutive collections may overlap. Up
+  ## to dedupe_cache_size of the most recently emitted points are remembered.
+  # dedupe = false
+  # dedupe_cache_size = 10000
+
+  ## Emit the latest point of each series of the last successful collection
+  ## of a resource again, tagged stale=true and timestamped with the time of
+  ## the collection, while gathering its metrics fails for up to this long
+  ## after that collection. Zero disables serving stale points.
+  # serve_stale_for = "0s"
+
+  ## Time window to request metrics for. Accepts either an ISO 8601 duration
+  ## ending at the time of the request, such as "PT5M" or "P1DT12H", or an
+  ## explicit pair of RFC3339 timestamps separated by a slash, such as
+  ## "2021-05-01T00:00:00Z/2021-05-01T01:00:00Z".
+  # timespan = "PT1M"
+
+  ## Fixed time window to request metrics for, as an alternative to timespan,
+  ## e.g. to backfill historical metrics. Both must be RFC3339 timestamps.
+  # start_time = "2021-05-01T00:00:00Z"
+  # end_time = "2021-05-02T00:00:00Z"
+
+  ## Granularity of the data points as an ISO 8601 duration, such as "PT1M"
+  ## or "PT1H". Azure picks the granularity if unset. Note that this is not
+  ## the collection interval of the plugin.
+  # aggregation_interval = ""
+
+  ## Shift the timestamps of the data points, which Azure reports at the start
+  ## of their aggregation interval, e.g. by the granularity of "PT1M" data
+  ## points to timestamp them at the end of the interval instead.
+  ##   timestamp_shift = "1m"
+  # timestamp_shift = "0s"
+
+  ## Let Azure pick the closest supported granularity for metrics that do not
+  ## support the aggregation_interval instead of failing the request.
+  # auto_adjust_timegrain = false
+
+  ## Fail requests whose filter references dimension values that do not
+  ## exist. Disable to return no time series for them instead.
+  # validate_dimensions = true
+
+  ## Request the metric definitions of the resources at startup and warn
+  ## about metrics configured with the metrics option that do not support the
+  ## requested aggregations, as Azure fails the whole request for those.
+  # validate_aggregations = false
+
+  ## Report the API cost of each request in the azure_monitor_cost
+  ## measurement, to keep track of the usage towards the throttling limits.
+  # report_cost = false
+
+  ## Report the time taken to request and parse the metrics of each resource
+  ## in the azure_monitor_internal measurement.
+  # report_gather_duration = false
+
+  ## Report the number of reads Azure allows before throttling requests to
+  ## the subscription of each resource in the azure_monitor_internal
+  ## measurement, to notice approaching rate limits.
+  # report_ratelimit = false
+
+  ## Report the HTTP status code of the response to the request for the
+  ## metrics of each resource in the azure_monitor_internal measurement, also
+  ## when the request failed, to alert on sustained throttling or
+  ## authorization failures.
+  # emit_status_code = false
+
+  ## Report whether the metrics of each resource could be requested in the
+  ## azure_monitor_up measurement, with up=1 on success and up=0 otherwise.
+  # report_up = false
+
+  ## Write the unit and display name of each metric to the azure_monitor_meta
+  ## measurement on every collection, instead of tagging all points with the
+  ## unit, to join against downstream.
+  # emit_metadata = false
+
+  ## Metrics Azure failed to compute carry an error code instead of "Success"
+  ## and are skipped by default. Enable to emit them with an error_code tag.
+  # tag_error_code = false
+
+  ## Report metrics with an error code in the azure_monitor_errors
+  ## measurement, whether they are skipped or not.
+  # emit_error_code = false
+
+  ## Resources without any metrics, e.g. because the metrics option matches
+  ## none of them, are logged as a warning. Enable to report them as errors.
+  # error_on_empty = false
+
+  ## Tag metrics with the ID of the resource they belong to. The
+  ## azure_monitor_cost and azure_monitor_internal measurements are always
+  ## tagged with the resource ID.
+  # include_resource_id_tag = true
+
+  ## Tag metrics with the resource group taken from the resource ID. Resource
+  ## IDs without a resource group, such as subscription-scoped IDs, are
+  ## rejected, so the tag is set on every point.
+  # include_resource_group_tag = false
+
+  ## Tag metrics with the subscription and the name of the resource taken from
+  ## the resource ID.
+  # include_subscription_tag = false
+  # include_resource_name_tag = false
+
+  ## Tag metrics with the namespace of the resource provider they belong to,
+  ## e.g. "Microsoft.Storage/storageAccounts".
+  # include_namespace_tag = true
+
+  ## Tag metrics with the region of the resource, e.g. "eastus".
+  # include_region_tag = true
+
+  ## Use the region of the Azure VM Telegraf runs on, requested once from the
+  ## instance metadata service, when Azure reports no region for a resource.
+  # imds_region_fallback = false
+
+  ## Tag metrics with the granularity Azure aggregated the data points with,
+  ## e.g. "PT1M".
+  # include_interval_tag = false
+
+  ## Tag metrics with the time window Azure returned the data points for,
+  ## which may differ from the requested one due to rounding. This increases
+  ## the cardinality of the series.
+  # include_timespan_tag = false
+
+  ## Tag metrics with their unit, such as "Bytes", "Count" or "Percent".
+  ## Metrics with differing units are written as separate points, so each
+  ## point carries the unit of all its fields.
+  # include_unit_tag = false
+
+  ## Tag metrics with their fully qualified ID. Every metric is written as a
+  ## separate point, and as the ID embeds the resource path this considerably
+  ## increases the cardinality of the series.
+  # include_metric_id_tag = false
+
+  ## Fields of the metrics in the response to tag the metrics with, tagged by
+  ## the same name; any of "type", "unit", "id" and "display_description".
+  # response_field_tags = []
+
+  ## Use the localized display name of metrics, e.g. "Blob Capacity", as field
+  ## name instead of the metric name. Spaces are replaced by underscores.
+  # use_localized_names = false
+
+  ## Convert metric names to snake case before using them as field names,
+  ## e.g. "SuccessE2ELatency" becomes "success_e2e_latency".
+  # normalize_field_names = false
+
+  ## Prefix prepended to every field name, e.g. "az_".
+  # field_prefix = ""
+
+  ## Separator between the metric and aggregation in field names such as
+  ## "BlobCapacity_total".
+  # field_separator = "_"
+
+  ## Emit each metric as a single field named after the metric, tagged with
+  ## the aggregation such as aggregation=Total, instead of one field per
+  ## aggregation suffixed with its name. Excludes field_separator.
+  # aggregation_as_tag = false
+
+  ## What to do when the response reports the same metric for more than one
+  ## namespace, so the values would end up in the same field:
+  ## "overwrite" the values of the earlier with the later ones logging a
+  ## warning, "prefix" the field names with the namespace of each metric, or
+  ## report an "error" and skip the later ones.
+  # duplicate_policy = "overwrite"
+
+  ## Factor the values of all metrics are multiplied with, e.g. 1e-9 to
+  ## convert bytes to gigabytes. The Count aggregation is never scaled as it
+  ## is a number of samples. Use the metric_scale table below to set the factor
+  ## of individual metrics, which takes precedence over value_scale.
+  # value_scale = 1.0
+
+  ## Number of decimal places to round the values to after scaling; negative
+  ## values disable rounding.
+  # value_precision = -1
+
+  ## The Azure cloud the resources are located in, one of "AzurePublic",
+  ## "AzureUSGovernment", "AzureChina" or "AzureGermany".
+  # cloud_name = "AzurePublic"
+
+  ## Resource manager endpoint to send requests to instead of the one of the
+  ## cloud, e.g. a regional endpoint. Tokens are still requested for the
+  ## token_audience below.
+  # management_endpoint = ""
+
+  ## Timeout for HTTP requests.
+  # timeout = "5s"
+
+  ## Number of times a request is retried after a transient error such as a
+  ## network failure or a 429, 500, 502, 503 or 504 response. Retries back off
+  ## exponentially unless the response requests a delay via Retry-After, up to
+  ## 30s between attempts.
+  ## Failures to authorize a request, e.g. rejected credentials, are not
+  ## retried.
+  # max_retries = 3
+
+  ## Maximum size of a response body; larger responses are rejected, e.g.
+  ## when too many metrics or dimension values are selected.
+  # max_response_body_size = "32MB"
+
+  ## Maximum number of pages requested for a resource when Azure splits the
+  ## metrics over several responses.
+  # max_pages = 10
+
+  ## User-Agent header sent with requests, allowing Azure support to identify
+  ## them; defaults to "Telegraf/<version> Go/<go version>".
+  # user_agent = ""
+
+  ## Request gzip compressed responses, which are decompressed transparently.
+  # enable_gzip = true
+
+  ## Maximum number of resources to request metrics for concurrently.
+  # max_concurrent_requests = 4
+
+  ## Maximum number of idle connections kept open in total and per host.
+  ## Raise max_idle_conns_per_host above max_concurrent_requests to reuse
+  ## connections when requesting many resources concurrently.
+  # max_idle_conns = 100
+  # max_idle_conns_per_host = 10
+
+  ## Maximum number of requests per second across all resources, to stay
+  ## within the read limits of the subscription. Requests are not limited if
+  ## unset or zero.
+  # requests_per_second = 0.0
+
+  ## Skip resources for the cooldown after the given number of consecutive
+  ## failed collections, e.g. after permissions are revoked. The resource is
+  ## requested once more after the cooldown. Disabled if unset or zero.
+  # circuit_breaker_threshold = 0
+  # circuit_breaker_cooldown = "5m"
+
+  ## HTTP or SOCKS5 proxy to send requests through, e.g.
+  ##   http_proxy_url = "http://localhost:8888"
+  ## The system wide proxy settings, such as HTTPS_PROXY, are used if unset.
+  # http_proxy_url = ""
+
+  ## Optional TLS Config
+  # tls_ca = "/etc/telegraf/ca.pem"
+  # tls_cert = "/etc/telegraf/cert.pem"
+  # tls_key = "/etc/telegraf/key.pem"
+  ## Use TLS but skip chain & host verification
+  # insecure_skip_verify = false
+
+  ## Authentication method, either "env" to use the service principal
+  ## configured below or, if unset, the credentials from the environment, or
+  ## "msi" to use the managed identity of the Azure VM. See the README for
+  ## details.
+  # auth_method = "env"
+
+  ## Credentials of a service principal. With "msi" authentication, client_id
+  ## optionally selects a user-assigned identity.
+  # client_id = ""
+  # client_secret = ""
+  # tenant_id = ""
+
+  ## PKCS#12 certificate of the service principal, used instead of
+  ## client_secret.
+  # client_cert_path = ""
+  # client_cert_password = ""
+
+  ## Service principal auth file as written by the Azure CLI with
+  ## "az ad sp create-for-rbac --sdk-auth"; used instead of the credentials
+  ## above with "env" authentication.
+  # auth_file = ""
+
+  ## Subscription the resources belong to; if set, resource IDs outside of
+  ## this subscription are rejected.
+  # subscription_id = ""
+
+  ## Audience access tokens are requested for; defaults to the resource
+  ## manager endpoint of the cloud, e.g. "https://management.azure.com/".
+  # token_audience = ""
+
+  ## Access tokens are reused until they expire within this buffer, at which
+  ## point a new token is requested.
+  # token_refresh_buffer = "5m"
+
+  ## Factors of individual metrics by metric name, overriding value_scale.
+  ## This table must follow all other options.
+  # [inputs.azure_monitor.metric_scale]
+  #   UsedCapacity = 1e-9
+
+  ## Names to report metrics under by metric name, used as is instead of the
+  ## localized or normalized names. This table must follow all other options.
+  # [inputs.azure_monitor.field_name_map]
+  #   SuccessServerLatency = "server_latency"
+
+  ## Resources with their own metrics and aggregations; the options above are
+  ## used for those left unset. These tables must follow all other options.
+  # [[inputs.azure_monitor.resource]]
+  #   resource_id = "/subscriptions/<subscription_id>/resourceGroups/<resource_group>/providers/Microsoft.Compute/virtualMachines/<vm>"
+  #   metrics = ["Percentage CPU"]
+  #   aggregations = ["Average", "Maximum"]
+`
+
+// Description provides a description of the plugin
+func (a *AzureMonitor) Description() string {
+	return "Gather metrics of Azure resources from Azure Monitor"
+}
+
+// SampleConfig provides a sample configuration for the plugin
+func (a *AzureMonitor) SampleConfig() string {
+	return sampleConfig
+}
+
+// Init validates the configuration and sets up the authorizer
+func (a *AzureMonitor) Init() error {
+	a.resourceIDs = a.resourceIDs[:0]
+	seen := make(map[string]bool)
+	for _, resourceID := range append([]string{a.ResourceId}, a.ResourceIds...) {
+		if resourceID == "" || seen[resourceID] {
+			continue
+		}
+		if err := validateResourceID(resourceID); err != nil {
+			return fmt.Errorf("invalid resource id %q: %v", resourceID, err)
+		}
+		seen[resourceID] = true
+		a.resourceIDs = append(a.resourceIDs, resourceID)
+	}
+	for _, resource := range a.Resources {
+		if resource.ResourceID == "" {
+			return errors.New("resource_id must be configured for each resource")
+		}
+		if err := validateResourceID(resource.ResourceID); err != nil {
+			return fmt.Errorf("invalid resource id %q: %v", resource.ResourceID, err)
+		}
+		if seen[resource.ResourceID] {
+			return fmt.Errorf("resource %q is configured more than once", resource.ResourceID)
+		}
+		seen[resource.ResourceID] = true
+		a.resourceIDs = append(a.resourceIDs, resource.ResourceID)
+	}
+	a.resourceFile = nil
+	if a.ResourceIdFile != "" {
+		a.resourceFile = &resourceIDFile{path: a.ResourceIdFile}
+		if _, err := a.resourceFile.load(a.validateSubscriptionResourceID); err != nil {
+			return fmt.Errorf("error reading resource_id_file %q: %v", a.ResourceIdFile, err)
+		}
+		a.Log.Debugf("Read %d resource IDs from %q", len(a.resourceFile.ids), a.ResourceIdFile)
+	}
+	if len(a.resourceIDs) == 0 && a.resourceFile == nil {
+		return errors.New("resource_id, resource_ids, resource_id_file or resource must be configured")
+	}
+	if a.FallbackResourceId != "" {
+		if a.ResourceId == "" {
+			return errors.New("fallback_resource_id requires resource_id")
+		}
+		if err := a.validateSubscriptionResourceID(a.FallbackResourceId); err != nil {
+			return fmt.Errorf("invalid fallback_resource_id %q: %v", a.FallbackResourceId, err)
+		}
+		if seen[a.FallbackResourceId] {
+			return fmt.Errorf("fallback_resource_id %q is also configured as a resource", a.FallbackResourceId)
+		}
+	}
+
+	if err := validateMetrics(a.Metrics); err != nil {
+		return err
+	}
+	metricFilter, err := filter.NewIncludeExcludeFilter(a.MetricInclude, a.MetricExclude)
+	if err != nil {
+		return fmt.Errorf("error compiling metric_include or metric_exclude: %v", err)
+	}
+	a.metricFilter = metricFilter
+
+	if a.WideRows && !a.LatestOnly {
+		return errors.New("wide_rows requires latest_only")
+	}
+
+	switch a.EmptySeriesPolicy {
+	case "":
+		a.EmptySeriesPolicy = emptySeriesSkip
+	case emptySeriesSkip, emptySeriesZero, emptySeriesWarn:
+	default:
+		return fmt.Errorf("invalid empty_series_policy %q, must be one of %s, %s, %s",
+			a.EmptySeriesPolicy, emptySeriesSkip, emptySeriesZero, emptySeriesWarn)
+	}
+
+	switch a.DuplicatePolicy {
+	case "":
+		a.DuplicatePolicy = duplicateOverwrite
+	case duplicateOverwrite, duplicatePrefix, duplicateError:
+	default:
+		return fmt.Errorf("invalid duplicate_policy %q, must be one of %s, %s, %s",
+			a.DuplicatePolicy, duplicateOverwrite, duplicatePrefix, duplicateError)
+	}
+
+	if a.Top < 0 {
+		return errors.New("top must be positive")
+	}
+
+	if a.Namespace != "" && !namespaceRe.MatchString(a.Namespace) {
+		return fmt.Errorf("invalid namespace %q, only letters, digits, '.', '_', '-' and '/' are allowed", a.Namespace)
+	}
+
+	if len(a.Aggregations) == 0 {
+		a.Aggregations = []string{"Average"}
+	}
+	if err := validateAggregations(a.Aggregations); err != nil {
+		return err
+	}
+
+	if a.OrderBy != "" {
+		if err := a.validateOrderBy(a.Aggregations); err != nil {
+			return fmt.Errorf("invalid order_by %q: %v", a.OrderBy, err)
+		}
+	}
+
+	a.resources = make(map[string]resourceSettings, len(a.Resources))
+	for _, resource := range a.Resources {
+		settings := resourceSettings{metrics: a.Metrics, aggregations: a.Aggregations}
+		if len(resource.Metrics) > 0 {
+			if err := validateMetrics(resource.Metrics); err != nil {
+				return fmt.Errorf("resource %q: %v", resource.ResourceID, err)
+			}
+			settings.metrics = resource.Metrics
+		}
+		if len(resource.Aggregations) > 0 {
+			if err := validateAggregations(resource.Aggregations); err != nil {
+				return fmt.Errorf("resource %q: %v", resource.ResourceID, err)
+			}
+			if a.OrderBy != "" {
+				if err := a.validateOrderBy(resource.Aggregations); err != nil {
+					return fmt.Errorf("resource %q: invalid order_by %q: %v", resource.ResourceID, a.OrderBy, err)
+				}
+			}
+			settings.aggregations = resource.Aggregations
+		}
+		a.resources[resource.ResourceID] = settings
+	}
+
+	if a.MeasurementName == "" {
+		a.MeasurementName = defaultMeasurementName
+	}
+	if strings.TrimSpace(a.MeasurementName) == "" {
+		return errors.New("measurement_name must not be blank")
+	}
+	if strings.IndexFunc(a.MeasurementName, unicode.IsControl) >= 0 {
+		return fmt.Errorf("invalid measurement_name %q, must not contain control characters", a.MeasurementName)
+	}
+
+	if a.ValueScale == 0 {
+		a.ValueScale = 1
+	}
+	// Rounding to more decimal places than a float64 holds has no effect
+	if a.ValuePrecision > maxValuePrecision {
+		return fmt.Errorf("value_precision must not exceed %d", maxValuePrecision)
+	}
+	for metric, scale := range a.MetricScale {
+		if scale == 0 {
+			return fmt.Errorf("metric_scale of %q must not be zero", metric)
+		}
+	}
+	for metric, name := range a.FieldNameMap {
+		if strings.TrimSpace(name) == "" || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return fmt.Errorf("invalid field_name_map name %q of %q, must not be blank or contain control characters", name, metric)
+		}
+	}
+
+	if a.AggregationAsTag && a.FieldSeparator != "" {
+		return errors.New("aggregation_as_tag and field_separator must not be configured together")
+	}
+	if a.FieldSeparator == "" {
+		a.FieldSeparator = defaultFieldSeparator
+	}
+	for _, name := range a.ResponseFieldTags {
+		if !isSupportedResponseFieldTag(name) {
+			return fmt.Errorf("unsupported response_field_tags entry %q, must be one of %s",
+				name, strings.Join(supportedResponseFieldTags, ", "))
+		}
+	}
+	if strings.IndexFunc(a.FieldSeparator, unicode.IsControl) >= 0 {
+		return fmt.Errorf("invalid field_separator %q, must not contain control characters", a.FieldSeparator)
+	}
+
+	if a.ApiVersion == "" {
+		a.ApiVersion = defaultAPIVersion
+	}
+	if !apiVersionRe.MatchString(a.ApiVersion) {
+		return fmt.Errorf("invalid api_version %q, expected the form YYYY-MM-DD", a.ApiVersion)
+	}
+
+	a.timespanDuration = 0
+	if a.StartTime != "" || a.EndTime != "" {
+		if a.Timespan != "" {
+			return errors.New("timespan and start_time/end_time must not be configured together")
+		}
+		if a.StartTime == "" || a.EndTime == "" {
+			return errors.New("start_time and end_time must be configured together")
+		}
+		if err := validateTimeRange(a.StartTime + "/" + a.EndTime); err != nil {
+			return fmt.Errorf("invalid start_time/end_time: %v", err)
+		}
+	} else if a.Timespan == "" {
+		a.timespanDuration = defaultTimespan
+	} else if !strings.Contains(a.Timespan, "/") {
+		d, err := parseDuration(a.Timespan)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timespan %q, expected an ISO 8601 duration or a start/end pair", a.Timespan)
+		}
+		a.timespanDuration = d
+	} else if err := validateTimeRange(a.Timespan); err != nil {
+		return fmt.Errorf("invalid timespan %q: %v", a.Timespan, err)
+	}
+
+	if a.Interval != "" {
+		if d, err := parseDuration(a.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid aggregation_interval %q, expected an ISO 8601 duration", a.Interval)
+		}
+	}
+
+	if a.Timeout == 0 {
+		a.Timeout = config.Duration(defaultTimeout)
+	}
+	if a.UserAgent == "" {
+		a.UserAgent = internal.ProductToken()
+	}
+
+	proxyFunc, err := a.HTTPProxy.Proxy()
+	if err != nil {
+		return err
+	}
+
+	tlsCfg, err := a.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	if a.MaxIdleConns < 0 {
+		return errors.New("max_idle_conns must not be negative")
+	}
+	if a.MaxIdleConns == 0 {
+		a.MaxIdleConns = defaultMaxIdleConns
+	}
+	if a.MaxIdleConnsPerHost < 0 {
+		return errors.New("max_idle_conns_per_host must not be negative")
+	}
+	if a.MaxIdleConnsPerHost == 0 {
+		a.MaxIdleConnsPerHost = defaultMaxIdleConnsHost
+	}
+
+	a.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:               proxyFunc,
+			TLSClientConfig:     tlsCfg,
+			MaxIdleConns:        a.MaxIdleConns,
+			MaxIdleConnsPerHost: a.MaxIdleConnsPerHost,
+			// The transport requests compressed responses and transparently
+			// decompresses them, including the bodies of error responses
+			DisableCompression: !a.EnableGzip,
+		},
+		Timeout: time.Duration(a.Timeout),
+	}
+
+	if a.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+	if a.MaxResponseBodySize < 0 {
+		return errors.New("max_response_body_size must not be negative")
+	}
+	if a.MaxResponseBodySize == 0 {
+		a.MaxResponseBodySize = config.Size(defaultMaxResponseSize)
+	}
+	if a.MaxPages < 0 {
+		return errors.New("max_pages must not be negative")
+	}
+	if a.MaxPages == 0 {
+		a.MaxPages = defaultMaxPages
+	}
+	if a.MaxConcurrentRequests == 0 {
+		a.MaxConcurrentRequests = defaultMaxConcurrency
+	}
+	if a.MaxConcurrentRequests < 0 {
+		return errors.New("max_concurrent_requests must not be negative")
+	}
+
+	if a.RequestsPerSecond < 0 {
+		return errors.New("requests_per_second must not be negative")
+	}
+	a.limiter = nil
+	if a.RequestsPerSecond > 0 {
+		a.limiter = rate.NewLimiter(rate.Limit(a.RequestsPerSecond), 1)
+	}
+
+	if a.CircuitBreakerThreshold < 0 {
+		return errors.New("circuit_breaker_threshold must not be negative")
+	}
+	if a.CircuitBreakerCooldown < 0 {
+		return errors.New("circuit_breaker_cooldown must not be negative")
+	}
+	if a.CircuitBreakerCooldown == 0 {
+		a.CircuitBreakerCooldown = config.Duration(defaultBreakerCooldown)
+	}
+	a.breaker = nil
+	if a.CircuitBreakerThreshold > 0 {
+		a.breaker = newCircuitBreaker(a.CircuitBreakerThreshold, time.Duration(a.CircuitBreakerCooldown), a.Log)
+	}
+
+	if a.DedupeCacheSize < 0 {
+		return errors.New("dedupe_cache_size must not be negative")
+	}
+	if a.DedupeCacheSize == 0 {
+		a.DedupeCacheSize = defaultDedupeCacheSize
+	}
+	a.dedupe = nil
+	if a.Dedupe {
+		a.dedupe = newDedupeCache(a.DedupeCacheSize)
+	}
+
+	if a.ServeStaleFor < 0 {
+		return errors.New("serve_stale_for must not be negative")
+	}
+	a.stale = nil
+	if a.ServeStaleFor > 0 {
+		a.stale = newStaleCache(time.Duration(a.ServeStaleFor))
+	}
+
+	if a.retryInterval == 0 {
+		a.retryInterval = defaultRetryInterval
+	}
+
+	if a.CloudName == "" {
+		a.CloudName = defaultCloudName
+	}
+	environment, ok := clouds[a.CloudName]
+	if !ok {
+		return fmt.Errorf("unknown cloud_name %q", a.CloudName)
+	}
+	a.environment = environment
+
+	if a.ManagementEndpoint != "" {
+		u, err := url.Parse(a.ManagementEndpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("invalid management_endpoint %q, must be an https URL", a.ManagementEndpoint)
+		}
+	}
+
+	if a.baseURL == "" {
+		a.baseURL = strings.TrimSuffix(a.environment.ResourceManagerEndpoint, "/")
+		if a.ManagementEndpoint != "" {
+			a.baseURL = strings.TrimSuffix(a.ManagementEndpoint, "/")
+		}
+	}
+	if a.timeFunc == nil {
+		a.timeFunc = time.Now
+	}
+	if a.imdsURL == "" {
+		a.imdsURL = defaultIMDSURL
+	}
+
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	if !a.IncludeResourceIDTag && !a.IncludeGroupTag && !a.IncludeSubscriptionTag && !a.IncludeResourceNameTag &&
+		!a.IncludeNamespaceTag && !a.IncludeRegionTag {
+		a.Log.Warn("The resource_id, resource_group, subscription_id, resource_name, namespace and region tags are disabled, points of metrics without dimensions will be untagged")
+	}
+
+	if a.SubscriptionID != "" {
+		prefix := "/subscriptions/" + strings.ToLower(a.SubscriptionID) + "/"
+		for _, resourceID := range a.resourceIDs {
+			if !strings.HasPrefix(strings.ToLower(resourceID), prefix) {
+				return fmt.Errorf("resource %q is not in subscription %q", resourceID, a.SubscriptionID)
+			}
+		}
+	}
+
+	if a.AuthMethod == "" {
+		a.AuthMethod = authMethodEnv
+	}
+	if a.TokenAudience == "" {
+		a.TokenAudience = a.environment.ResourceManagerEndpoint
+	}
+	if u, err := url.Parse(a.TokenAudience); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid token_audience %q, must be an https URL", a.TokenAudience)
+	}
+	if a.TokenRefreshBuffer < 0 {
+		return errors.New("token_refresh_buffer must not be negative")
+	}
+	if a.TokenRefreshBuffer == 0 {
+		a.TokenRefreshBuffer = config.Duration(defaultRefreshBuffer)
+	}
+	if err := a.validateCredentials(); err != nil {
+		return err
+	}
+
+	a.authorizer, err = a.newAuthorizer()
+	if err != nil {
+		return fmt.Errorf("error creating authorizer: %v", err)
+	}
+
+	if a.ValidateAggregations {
+		a.warnUnsupportedAggregations()
+	}
+
+	return nil
+}
+
+// parseDuration parses an ISO 8601 duration. Only days and smaller units are
+// supported as years and months have no fixed length.
+func parseDuration(s string) (time.Duration, error) {
+	matches := durationRe.FindStringSubmatch(s)
+	if matches == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+
+	var d time.Duration
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	for i, unit := range units {
+		if matches[i+1] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(matches[i+1], 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(v * float64(unit))
+	}
+	return d, nil
+}
+
+// validateTimeRange checks that a "<start>/<end>" pair consists of RFC3339
+// timestamps with the start preceding the end.
+func validateTimeRange(s string) error {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return errors.New("expected a start/end pair")
+	}
+	start, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid start time: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid end time: %v", err)
+	}
+	if !start.Before(end) {
+		return errors.New("start time must precede end time")
+	}
+	return nil
+}
+
+// resourceIDSegment returns the segment of the resource ID following the
+// given key, such as the resource group name after "resourceGroups", or an
+// empty string if the ID has no such segment.
+func resourceIDSegment(resourceID, key string) string {
+	segments := strings.Split(strings.Trim(resourceID, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if strings.EqualFold(segments[i], key) {
+			return segments[i+1]
+		}
+	}
+	return ""
+}
+
+// namespacePrefix turns a namespace into a prefix of field names
+var namespacePrefix = strings.NewReplacer("/", "_", ".", "_")
+
+// valueNamespace returns the namespace of the resource a metric is reported
+// for, derived from its ID such as
+// ".../providers/Microsoft.Storage/storageAccounts/account/blobServices/default/providers/Microsoft.Insights/metrics/BlobCapacity"
+// for "Microsoft.Storage/storageAccounts/blobServices". The namespace of the
+// response is returned for IDs of another form.
+func valueNamespace(id, responseNamespace string) string {
+	i := strings.LastIndex(strings.ToLower(id), "/providers/microsoft.insights/metrics/")
+	if i < 0 {
+		return responseNamespace
+	}
+	resourceURI := id[:i]
+	j := strings.LastIndex(strings.ToLower(resourceURI), "/providers/")
+	if j < 0 {
+		return responseNamespace
+	}
+
+	// The provider is followed by pairs of resource type and name
+	segments := strings.Split(resourceURI[j+len("/providers/"):], "/")
+	namespace := segments[0]
+	for k := 1; k < len(segments); k += 2 {
+		namespace += "/" + segments[k]
+	}
+	return namespace
+}
+
+// validateResourceID checks that id has the shape of an ARM resource ID and
+// otherwise describes the first malformed segment.
+func validateResourceID(id string) error {
+	if resourceIDRe.MatchString(id) {
+		return nil
+	}
+
+	if !strings.HasPrefix(id, "/") {
+		return errors.New("must start with a slash")
+	}
+	if strings.HasSuffix(id, "/") {
+		return errors.New("must not end with a slash")
+	}
+
+	segments := strings.Split(id[1:], "/")
+	for i, expected := range resourceIDSegments {
+		if i >= len(segments) {
+			return fmt.Errorf("missing %s", expected.description)
+		}
+		if !expected.re.MatchString(segments[i]) {
+			return fmt.Errorf("segment %d is %q, expected %s", i+1, segments[i], expected.description)
+		}
+	}
+
+	rest := segments[len(resourceIDSegments):]
+	for i, segment := range rest {
+		if segment == "" {
+			return fmt.Errorf("segment %d is empty", len(resourceIDSegments)+i+1)
+		}
+	}
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return errors.New("expected pairs of resource type and name after the provider namespace")
+	}
+	return nil
+}
+
+// validateMetrics checks that metric names can be joined into the
+// metricnames parameter.
+func validateMetrics(metrics []string) error {
+	for _, metric := range metrics {
+		if strings.Contains(metric, ",") {
+			return fmt.Errorf("invalid metric name %q, must not contain a comma", metric)
+		}
+	}
+	return nil
+}
+
+func validateAggregations(aggregations []string) error {
+	for _, aggregation := range aggregations {
+		if !isSupportedAggregation(aggregation) {
+			return fmt.Errorf("unsupported aggregation %q, must be one of %s",
+				aggregation, strings.Join(supportedAggregations, ", "))
+		}
+	}
+	return nil
+}
+
+// validateOrderBy checks that order_by sorts by one of the given
+// aggregations in either direction.
+func (a *AzureMonitor) validateOrderBy(aggregations []string) error {
+	parts := strings.Fields(a.OrderBy)
+	if len(parts) != 2 {
+		return errors.New(`expected an aggregation followed by "asc" or "desc"`)
+	}
+
+	requested := false
+	for _, aggregation := range aggregations {
+		if strings.EqualFold(parts[0], aggregation) {
+			requested = true
+			break
+		}
+	}
+	if !requested {
+		return fmt.Errorf("aggregation %q is not requested", parts[0])
+	}
+
+	switch strings.ToLower(parts[1]) {
+	case "asc", "desc":
+		return nil
+	default:
+		return fmt.Errorf(`unknown direction %q, must be "asc" or "desc"`, parts[1])
+	}
+}
+
+func isSupportedAggregation(aggregation string) bool {
+	for _, supported := range supportedAggregations {
+		if aggregation == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func isSupportedResponseFieldTag(name string) bool {
+	for _, supported := range supportedResponseFieldTags {
+		if name == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// Gather requests the metrics of each resource and adds them to the
+// accumulator. Failures of a single resource are reported to the accumulator
+// so the remaining resources are still gathered.
+func (a *AzureMonitor) Gather(acc telegraf.Accumulator) error {
+	ids, err := a.gatherResourceIDs()
+	if err != nil {
+		acc.AddError(err)
+	}
+
+	resourceIDs := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < a.MaxConcurrentRequests && i < len(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for resourceID := range resourceIDs {
+				gathered, err := a.gatherWithBreaker(acc, resourceID, false)
+				if (!gathered || err != nil) && resourceID == a.ResourceId && a.FallbackResourceId != "" {
+					if err != nil {
+						a.Log.Warnf("Failing over to %q: %v", a.FallbackResourceId, err)
+					} else {
+						a.Log.Warnf("Failing over to %q, circuit breaker of %q is open", a.FallbackResourceId, resourceID)
+					}
+					gathered, err = a.gatherWithBreaker(acc, a.FallbackResourceId, true)
+				}
+				if (!gathered || err != nil) && a.stale != nil && a.stale.serve(acc, resourceID, a.timeFunc()) {
+					a.Log.Debugf("Serving stale points of %q", resourceID)
+				}
+				if err != nil {
+					acc.AddError(err)
+				}
+			}
+		}()
+	}
+
+	for _, resourceID := range ids {
+		resourceIDs <- resourceID
+	}
+	close(resourceIDs)
+	wg.Wait()
+
+	return nil
+}
+
+// gatherWithBreaker gathers the metrics of the resource unless its circuit
+// breaker is open, and reports whether the resource was requested at all.
+func (a *AzureMonitor) gatherWithBreaker(acc telegraf.Accumulator, resourceID string, failover bool) (bool, error) {
+	if a.breaker != nil && !a.breaker.allow(resourceID, a.timeFunc()) {
+		a.Log.Debugf("Skipping %q, circuit breaker is open", resourceID)
+		a.addUp(acc, resourceID, false)
+		return false, nil
+	}
+	err := a.gatherResource(a.ctx, acc, resourceID, failover)
+	if a.breaker != nil {
+		a.breaker.record(resourceID, err, a.timeFunc())
+	}
+	var emptyErr *AzureMonitorEmptyError
+	up := err == nil || errors.As(err, &emptyErr)
+	if up {
+		a.recordSuccess(resourceID, a.timeFunc())
+	}
+	a.addUp(acc, resourceID, up)
+	return true, err
+}
+
+// recordSuccess remembers when the metrics of the resource were last
+// requested and parsed successfully
+func (a *AzureMonitor) recordSuccess(resourceID string, t time.Time) {
+	a.successMu.Lock()
+	defer a.successMu.Unlock()
+
+	if a.lastSuccess == nil {
+		a.lastSuccess = make(map[string]time.Time)
+	}
+	a.lastSuccess[resourceID] = t
+}
+
+// LastSuccess returns when the metrics of the resource were last gathered
+// successfully, and false if that has not happened yet.
+func (a *AzureMonitor) LastSuccess(resourceID string) (time.Time, bool) {
+	a.successMu.Lock()
+	defer a.successMu.Unlock()
+
+	t, ok := a.lastSuccess[resourceID]
+	return t, ok
+}
+
+// Start is a no-op; metrics are gathered by Gather. The plugin implements
+// telegraf.ServiceInput only so that Stop can cancel in-flight requests.
+func (a *AzureMonitor) Start(telegraf.Accumulator) error {
+	return nil
+}
+
+// Stop cancels any in-flight requests and closes idle connections when
+// Telegraf shuts down or reloads. It is safe to call if Init failed.
+func (a *AzureMonitor) Stop() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	if a.client != nil {
+		a.client.CloseIdleConnections()
+	}
+}
+
+func (a *AzureMonitor) gatherResource(ctx context.Context, acc telegraf.Accumulator, resourceID string, failover bool) error {
+	now := a.timeFunc()
+	start := time.Now()
+
+	resp, err := a.makeRequest(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	// Reported on return so that the status code of failed requests is
+	// included as well
+	internalFields := make(map[string]interface{})
+	defer func() {
+		if len(internalFields) > 0 {
+			acc.AddFields(internalMeasurementName, internalFields, map[string]string{"resource_id": resourceID}, now)
+		}
+	}()
+	if a.EmitStatusCode {
+		internalFields["status_code"] = int64(resp.StatusCode)
+	}
+
+	// Azure reports the number of reads left before requests to the
+	// subscription are throttled
+	remaining, remainingErr := strconv.ParseInt(resp.Header.Get("x-ms-ratelimit-remaining-subscription-reads"), 10, 64)
+	if remainingErr == nil {
+		a.Log.Debugf("%d reads remaining before requests for %q are throttled", remaining, resourceID)
+	}
+
+	monitorResponse, err := a.parseResponse(resp, resourceID)
+	if err != nil {
+		return err
+	}
+	if err := a.followNextLinks(ctx, resourceID, monitorResponse); err != nil {
+		return err
+	}
+
+	if a.ReportGatherDuration {
+		internalFields["gather_duration_ms"] = time.Since(start).Milliseconds()
+	}
+	if a.ReportRateLimit && remainingErr == nil {
+		internalFields["ratelimit_remaining"] = remaining
+	}
+
+	if a.ReportCost {
+		acc.AddFields(costMeasurementName,
+			map[string]interface{}{"cost": monitorResponse.Cost},
+			map[string]string{"resource_id": resourceID},
+			now)
+	}
+
+	if a.metricFilter != nil {
+		values := monitorResponse.Value[:0]
+		for _, value := range monitorResponse.Value {
+			if a.metricFilter.Match(value.Name.Value) {
+				values = append(values, value)
+			}
+		}
+		monitorResponse.Value = values
+	}
+
+	if len(monitorResponse.Value) == 0 {
+		err := &AzureMonitorEmptyError{ResourceID: resourceID}
+		if a.ErrorOnEmpty {
+			return err
+		}
+		a.Log.Warnf("%v, check the metrics and namespace options", err)
+		return nil
+	}
+
+	if a.EmitMetadata {
+		a.addMetadata(acc, resourceID, monitorResponse, now)
+	}
+
+	fieldsByTimestamp := make(map[bucketKey]*bucket)
+	timestamps := make(map[string]time.Time)
+	responseTags := make(map[string]string)
+	if a.IncludeResourceIDTag {
+		responseTags["resource_id"] = resourceID
+	}
+	if a.IncludeGroupTag {
+		if group := resourceIDSegment(resourceID, "resourceGroups"); group != "" {
+			responseTags["resource_group"] = group
+		}
+	}
+	if a.IncludeSubscriptionTag {
+		if subscription := resourceIDSegment(resourceID, "subscriptions"); subscription != "" {
+			responseTags["subscription_id"] = subscription
+		}
+	}
+	if a.IncludeResourceNameTag {
+		responseTags["resource_name"] = resourceID[strings.LastIndex(resourceID, "/")+1:]
+	}
+	if a.IncludeNamespaceTag && monitorResponse.Namespace != "" {
+		responseTags["namespace"] = monitorResponse.Namespace
+	}
+	if a.IncludeRegionTag {
+		region := monitorResponse.ResourceRegion
+		if region == "" && a.IMDSRegionFallback {
+			region = a.fallbackRegion(ctx)
+		}
+		if region != "" {
+			responseTags["region"] = region
+		}
+	}
+	if a.IncludeIntervalTag && monitorResponse.Interval != "" {
+		responseTags["interval"] = monitorResponse.Interval
+	}
+	if a.IncludeTimespanTag && monitorResponse.Timespan != "" {
+		responseTags["timespan"] = monitorResponse.Timespan
+	}
+	if failover {
+		responseTags["failover"] = "true"
+	}
+
+	settings := a.settings(resourceID)
+
+	// The field names and tags of the aggregations of a time series are
+	// resolved once per series rather than for each of its data points
+	type seriesField struct {
+		aggregation string
+		name        string
+		tags        map[string]string
+		tagsKey     string
+	}
+	seriesFields := func(tags map[string]string, field string) []seriesField {
+		fields := make([]seriesField, 0, len(settings.aggregations))
+		key := tagsKey(tags)
+		for _, aggregation := range settings.aggregations {
+			f := seriesField{
+				aggregation: aggregation,
+				name:        a.fieldName(field, aggregation, settings.aggregations),
+				tags:        tags,
+				tagsKey:     key,
+			}
+			if a.AggregationAsTag {
+				f.tags = make(map[string]string, len(tags)+1)
+				for k, v := range tags {
+					f.tags[k] = v
+				}
+				f.tags["aggregation"] = aggregation
+				f.tagsKey = tagsKey(f.tags)
+			}
+			fields = append(fields, f)
+		}
+		return fields
+	}
+	addField := func(measurement string, timestamp time.Time, f seriesField, v, scale float64) {
+		key := bucketKey{measurement: measurement, timestamp: timestamp, tags: f.tagsKey}
+		slot, ok := fieldsByTimestamp[key]
+		if !ok {
+			slot = newBucket(f.tags)
+			fieldsByTimestamp[key] = slot
+		}
+		aggregation := f.aggregation
+		var fieldValue interface{} = a.round(v * scale)
+		if aggregation == "Count" {
+			fieldValue = a.round(v)
+			// With aggregation_as_tag the count shares its field with the
+			// float aggregations, so it must have the same type
+			if a.CountAsInteger && !a.AggregationAsTag {
+				fieldValue = int64(math.Round(v))
+			}
+		}
+		slot.fields[f.name] = fieldValue
+	}
+
+	// Metrics reported for more than one namespace would end up in the same
+	// fields
+	namespaces := make(map[string]map[string]bool)
+	for _, value := range monitorResponse.Value {
+		metric := a.metricName(value.Name)
+		if namespaces[metric] == nil {
+			namespaces[metric] = make(map[string]bool)
+		}
+		namespaces[metric][valueNamespace(value.Id, monitorResponse.Namespace)] = true
+	}
+	seenNamespaces := make(map[string]string)
+
+	for _, value := range monitorResponse.Value {
+		valueTags := make(map[string]string, len(responseTags))
+		for k, v := range responseTags {
+			valueTags[k] = v
+		}
+		if value.ErrorCode != "" && value.ErrorCode != "Success" {
+			if a.EmitErrorCode {
+				acc.AddFields(errorsMeasurementName,
+					map[string]interface{}{"error_code": value.ErrorCode, "count": 1},
+					map[string]string{"resource_id": resourceID, "metric": value.Name.Value},
+					now)
+			}
+			if !a.TagErrorCode {
+				a.Log.Warnf("Skipping metric %q of %q: error code %q", value.Name.Value, resourceID, value.ErrorCode)
+				continue
+			}
+			a.Log.Warnf("Metric %q of %q has error code %q", value.Name.Value, resourceID, value.ErrorCode)
+			valueTags["error_code"] = value.ErrorCode
+		}
+		if a.IncludeUnitTag && value.Unit != "" {
+			valueTags["unit"] = value.Unit
+		}
+		if a.IncludeMetricIDTag && value.Id != "" {
+			valueTags["metric_id"] = value.Id
+		}
+		for _, name := range a.ResponseFieldTags {
+			if v := value.field(name); v != "" {
+				valueTags[name] = v
+			}
+		}
+
+		metric := a.metricName(value.Name)
+		if len(namespaces[metric]) > 1 {
+			namespace := valueNamespace(value.Id, monitorResponse.Namespace)
+			first, seen := seenNamespaces[metric]
+			duplicate := seen && first != namespace
+			if !seen {
+				seenNamespaces[metric] = namespace
+			}
+			switch a.DuplicatePolicy {
+			case duplicatePrefix:
+				metric = namespacePrefix.Replace(namespace) + a.FieldSeparator + metric
+			case duplicateError:
+				if duplicate {
+					acc.AddError(fmt.Errorf("metric %q of %q is reported more than once, skipping it", value.Name.Value, resourceID))
+					continue
+				}
+			default:
+				if duplicate {
+					a.Log.Warnf("Metric %q of %q is reported more than once, overwriting its earlier values", value.Name.Value, resourceID)
+				}
+			}
+		}
+		scale := a.scale(value.Name.Value)
+		measurement, field := a.MeasurementName, metric
+		if a.MetricPerMeasurement {
+			measurement, field = a.MeasurementName+"_"+metric, "value"
+		}
+		for _, timeseries := range value.Timeseries {
+			seriesField := field
+			var seriesTags map[string]string
+			if a.DimensionsAsSuffix {
+				seriesTags = make(map[string]string, len(valueTags))
+				if suffix := dimensionSuffix(timeseries.MetadataValues); suffix != "" {
+					seriesField += "_" + suffix
+				}
+			} else {
+				seriesTags = dimensionTags(timeseries.MetadataValues)
+			}
+			for k, v := range valueTags {
+				seriesTags[k] = v
+			}
+			fields := seriesFields(seriesTags, seriesField)
+			if len(timeseries.Data) == 0 {
+				switch a.EmptySeriesPolicy {
+				case emptySeriesWarn:
+					a.Log.Warnf("Skipping time series without data points of metric %q of %q", metric, resourceID)
+				case emptySeriesZero:
+					for _, f := range fields {
+						addField(measurement, now, f, 0, scale)
+					}
+				}
+				continue
+			}
+			data := timeseries.Data
+			if a.LatestOnly {
+				data = a.latestDatum(data, settings.aggregations)
+			}
+			for _, datum := range data {
+				t, ok := timestamps[datum.TimeStamp]
+				if !ok {
+					var err error
+					t, err = parseTimestamp(datum.TimeStamp)
+					if err != nil {
+						a.Log.Debugf("Skipping data point of metric %q of %q with invalid timestamp %q: %v",
+							metric, resourceID, datum.TimeStamp, err)
+						continue
+					}
+					t = t.Add(time.Duration(a.TimestampShift))
+					timestamps[datum.TimeStamp] = t
+				}
+
+				if a.WideRows {
+					t = now
+				}
+				for _, f := range fields {
+					if v, ok := datum.value(f.aggregation); ok {
+						addField(measurement, t, f, v, scale)
+					}
+				}
+			}
+		}
+	}
+
+	if a.stale != nil {
+		// Only the latest point of each series is served again, as they are
+		// all timestamped with the time of the collection then
+		latest := make(map[bucketKey]bucketKey)
+		for key := range fieldsByTimestamp {
+			series := bucketKey{measurement: key.measurement, tags: key.tags}
+			if previous, ok := latest[series]; !ok || key.timestamp.After(previous.timestamp) {
+				latest[series] = key
+			}
+		}
+		points := make([]stalePoint, 0, len(latest))
+		for _, key := range latest {
+			slot := fieldsByTimestamp[key]
+			points = append(points, stalePoint{measurement: key.measurement, tags: slot.tags, fields: slot.fields})
+		}
+		a.stale.store(resourceID, now, points)
+	}
+
+	for key, slot := range fieldsByTimestamp {
+		if a.dedupe != nil && a.dedupe.seen(dedupeKey{resourceID: resourceID, bucketKey: key}, slot.fields) {
+			continue
+		}
+		acc.AddFields(key.measurement, slot.fields, slot.tags, key.timestamp)
+	}
+
+	return nil
+}
+
+// round rounds the value to value_precision decimal places, if configured
+func (a *AzureMonitor) round(v float64) float64 {
+	if a.ValuePrecision < 0 {
+		return v
+	}
+	factor := math.Pow10(a.ValuePrecision)
+	return math.Round(v*factor) / factor
+}
+
+// followNextLinks requests the remaining pages of a paginated response, up to
+// max_pages in total, and appends their metrics and cost to the response.
+// Links are only followed to the endpoint the first page was requested from
+// as the requests carry the bearer token.
+func (a *AzureMonitor) followNextLinks(ctx context.Context, resourceID string, monitorResponse *AzureMonitorResponse) error {
+	for pages := 1; monitorResponse.NextLink != ""; pages++ {
+		if pages >= a.MaxPages {
+			a.Log.Warnf("Stopping after %d pages of metrics of %q, increase max_pages to gather all metrics", pages, resourceID)
+			return nil
+		}
+		if !strings.HasPrefix(monitorResponse.NextLink, a.baseURL+"/") {
+			return fmt.Errorf("refusing to follow next link %q of resource %q outside of %q",
+				monitorResponse.NextLink, resourceID, a.baseURL)
+		}
+
+		resp, err := a.requestWithRetries(ctx, resourceID, monitorResponse.NextLink)
+		if err != nil {
+			return err
+		}
+		page, err := a.parseResponse(resp, resourceID)
+		if err != nil {
+			return err
+		}
+		mergeValues(monitorResponse, page.Value)
+		monitorResponse.Cost += page.Cost
+		monitorResponse.NextLink = page.NextLink
+	}
+	return nil
+}
+
+// mergeValues adds the metrics of a further page to the response. Azure
+// splits the time series of a metric across pages, so those of a metric
+// already in the response are appended to it.
+func mergeValues(monitorResponse *AzureMonitorResponse, values []AzureMonitorResponseValue) {
+	index := make(map[string]int, len(monitorResponse.Value))
+	for i, value := range monitorResponse.Value {
+		if value.Id != "" {
+			index[value.Id] = i
+		}
+	}
+	for _, value := range values {
+		if i, ok := index[value.Id]; ok {
+			monitorResponse.Value[i].Timeseries = append(monitorResponse.Value[i].Timeseries, value.Timeseries...)
+			continue
+		}
+		if value.Id != "" {
+			index[value.Id] = len(monitorResponse.Value)
+		}
+		monitorResponse.Value = append(monitorResponse.Value, value)
+	}
+}
+
+// addUp reports whether the metrics of the resource could be requested and
+// parsed, if enabled
+func (a *AzureMonitor) addUp(acc telegraf.Accumulator, resourceID string, up bool) {
+	if !a.ReportUp {
+		return
+	}
+	value := 0
+	if up {
+		value = 1
+	}
+	acc.AddFields(upMeasurementName,
+		map[string]interface{}{"up": value},
+		map[string]string{"resource_id": resourceID},
+		a.timeFunc())
+}
+
+// addMetadata writes the unit and display name of each metric in the
+// response to the azure_monitor_meta measurement.
+func (a *AzureMonitor) addMetadata(acc telegraf.Accumulator, resourceID string, monitorResponse *AzureMonitorResponse, now time.Time) {
+	for _, value := range monitorResponse.Value {
+		fields := map[string]interface{}{
+			"unit":         value.Unit,
+			"display_name": value.Name.LocalizedValue,
+		}
+		if monitorResponse.Namespace != "" {
+			fields["namespace"] = monitorResponse.Namespace
+		}
+		if value.DisplayDescription != "" {
+			fields["description"] = value.DisplayDescription
+		}
+		tags := map[string]string{
+			"resource_id": resourceID,
+			"metric":      value.Name.Value,
+		}
+		acc.AddFields(metaMeasurementName, fields, tags, now)
+	}
+}
+
+// bucketKey identifies a point; time series with differing tags, such as
+// dimension values, share timestamps and must not be merged into the same
+// point.
+type bucketKey struct {
+	measurement string
+	timestamp   time.Time
+	tags        string
+}
+
+// bucket collects the fields of a single point
+type bucket struct {
+	tags   map[string]string
+	fields map[string]interface{}
+}
+
+// newBucket creates the point of a time series at a timestamp. The tags are
+// shared by the points of the series and must not be modified.
+func newBucket(seriesTags map[string]string) *bucket {
+	return &bucket{
+		tags:   seriesTags,
+		fields: make(map[string]interface{}),
+	}
+}
+
+// dimensionTags converts the dimension values of a time series to tags
+func dimensionTags(metadata []AzureMonitorResponseMetadataValue) map[string]string {
+	tags := make(map[string]string, len(metadata))
+	for _, m := range metadata {
+		if m.Name.Value == "" {
+			continue
+		}
+		tags[m.Name.Value] = m.Value
+	}
+	return tags
+}
+
+// dimensionSuffix joins the dimension values of a time series ordered by the
+// dimension names, so the suffix does not depend on the order of the response
+func dimensionSuffix(metadata []AzureMonitorResponseMetadataValue) string {
+	sorted := make([]AzureMonitorResponseMetadataValue, 0, len(metadata))
+	for _, m := range metadata {
+		if m.Name.Value != "" {
+			sorted = append(sorted, m)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name.Value < sorted[j].Name.Value
+	})
+
+	values := make([]string, 0, len(sorted))
+	for _, m := range sorted {
+		values = append(values, m.Value)
+	}
+	return strings.Join(values, "_")
+}
+
+// tagsKey returns a canonical string representation of a tag set
+func tagsKey(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(tags[k])
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+// metricName returns the name a metric is reported under. Names mapped by
+// field_name_map are used as is.
+func (a *AzureMonitor) metricName(name AzureMonitorResponseValueName) string {
+	if mapped, ok := a.FieldNameMap[name.Value]; ok {
+		return mapped
+	}
+	metric := name.Value
+	if a.UseLocalizedNames && name.LocalizedValue != "" {
+		metric = strings.ReplaceAll(name.LocalizedValue, " ", "_")
+	}
+	if a.NormalizeFieldNames {
+		metric = snakeCase(metric)
+	}
+	return metric
+}
+
+// snakeCase lowercases a camel case name and separates its words with
+// underscores. Acronyms are kept together, so "SuccessE2ELatency" becomes
+// "success_e2e_latency", and spaces are replaced by underscores.
+func snakeCase(name string) string {
+	runes := []rune(strings.ReplaceAll(name, " ", "_"))
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || (nextLower && (unicode.IsUpper(prev) || unicode.IsDigit(prev))) {
+				sb.WriteRune('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// settings returns the metrics and aggregations requested for a resource
+func (a *AzureMonitor) settings(resourceID string) resourceSettings {
+	if settings, ok := a.resources[resourceID]; ok {
+		return settings
+	}
+	return resourceSettings{metrics: a.Metrics, aggregations: a.Aggregations}
+}
+
+// scale returns the factor the values of the metric are multiplied with
+func (a *AzureMonitor) scale(metric string) float64 {
+	if scale, ok := a.MetricScale[metric]; ok {
+		return scale
+	}
+	return a.ValueScale
+}
+
+// fieldName returns the field key of a metric's aggregation. The plain metric
+// name is kept when only the average is requested so existing series are not
+// renamed, and when the aggregation is tagged instead.
+func (a *AzureMonitor) fieldName(metric, aggregation string, aggregations []string) string {
+	if a.AggregationAsTag || (len(aggregations) == 1 && aggregations[0] == "Average") {
+		return a.FieldPrefix + metric
+	}
+	return a.FieldPrefix + metric + a.FieldSeparator + strings.ToLower(aggregation)
+}
+
+// latestDatum returns the data point with the most recent valid timestamp
+// that has a value for any of the requested aggregations, if there is one.
+func (a *AzureMonitor) latestDatum(data []AzureMonitorResponseTimeSeriesDatum, aggregations []string) []AzureMonitorResponseTimeSeriesDatum {
+	latest := -1
+	var latestTime time.Time
+	for i, datum := range data {
+		t, err := parseTimestamp(datum.TimeStamp)
+		if err != nil || (latest >= 0 && !t.After(latestTime)) {
+			continue
+		}
+		for _, aggregation := range aggregations {
+			if _, ok := datum.value(aggregation); ok {
+				latest, latestTime = i, t
+				break
+			}
+		}
+	}
+	if latest < 0 {
+		return nil
+	}
+	return data[latest : latest+1]
+}
+
+// parseTimestamp parses the timestamp of a data point, which may have
+// sub-second precision. Timestamps without a time zone are taken as UTC.
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05.999999999", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// value returns the value of the given aggregation and whether it was present
+// in the response. Azure omits aggregations for intervals without data.
+func (d *AzureMonitorResponseTimeSeriesDatum) value(aggregation string) (float64, bool) {
+	var v *float64
+	switch aggregation {
+	case "Average":
+		v = d.Average
+	case "Total":
+		v = d.Total
+	case "Minimum":
+		v = d.Minimum
+	case "Maximum":
+		v = d.Maximum
+	case "Count":
+		v = d.Count
+	}
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
+
+func (a *AzureMonitor) makeRequest(ctx context.Context, resourceID string) (*http.Response, error) {
+	query := url.Values{}
+	query.Set("api-version", a.ApiVersion)
+	settings := a.settings(resourceID)
+	query.Set("aggregation", strings.Join(settings.aggregations, ","))
+	query.Set("timespan", a.timespan())
+	if len(settings.metrics) > 0 {
+		query.Set("metricnames", strings.Join(settings.metrics, ","))
+	}
+	if a.Namespace != "" {
+		query.Set("metricnamespace", a.Namespace)
+	}
+	if a.Filter != "" {
+		query.Set("$filter", a.Filter)
+	}
+	if a.Interval != "" {
+		query.Set("interval", a.Interval)
+	}
+	if a.AutoAdjustTimegrain {
+		query.Set("AutoAdjustTimegrain", "true")
+	}
+	if !a.ValidateDimensions {
+		query.Set("validateDimensions", "false")
+	}
+	if a.Top > 0 {
+		query.Set("top", strconv.Itoa(a.Top))
+	}
+	if a.OrderBy != "" {
+		query.Set("orderby", a.OrderBy)
+	}
+	requestURL := fmt.Sprintf("%s%s/providers/microsoft.insights/metrics?%s",
+		a.baseURL, resourceID, query.Encode())
+
+	// The bearer token is only added to the headers when sending the request,
+	// so the URL can be logged as is.
+	metrics := "all"
+	if len(settings.metrics) > 0 {
+		metrics = strings.Join(settings.metrics, ",")
+	}
+	a.Log.Debugf("Requesting metrics %s with aggregations %s for resource %q: %s",
+		metrics, strings.Join(settings.aggregations, ","), resourceID, requestURL)
+
+	return a.requestWithRetries(ctx, resourceID, requestURL)
+}
+
+// requestWithRetries requests the given URL on behalf of the resource,
+// retrying transient errors until the context is cancelled. Failures to
+// create or authorize the request are returned right away.
+func (a *AzureMonitor) requestWithRetries(ctx context.Context, resourceID, requestURL string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if a.limiter != nil {
+			if err := a.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := a.prepareRequest(ctx, requestURL)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := a.doRequest(req)
+		if attempt >= a.MaxRetries || ctx.Err() != nil || !isRetryable(resp, err) {
+			return resp, err
+		}
+
+		wait := a.retryBackoff(resp, attempt)
+		if err != nil {
+			a.Log.Debugf("Request for %q failed, retrying in %s (%d/%d): %v",
+				resourceID, wait, attempt+1, a.MaxRetries, err)
+		} else {
+			a.Log.Debugf("Request for %q returned status %d, retrying in %s (%d/%d)",
+				resourceID, resp.StatusCode, wait, attempt+1, a.MaxRetries)
+			// Drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// TestConnection requests the metrics of each configured resource, including
+// those listed in resource_id_file, once and reports the first failure,
+// without emitting any metrics. It allows checking credentials and resource
+// IDs before deploying a configuration.
+func (a *AzureMonitor) TestConnection() error {
+	resourceIDs, err := a.gatherResourceIDs()
+	if err != nil {
+		return err
+	}
+	for _, resourceID := range resourceIDs {
+		resp, err := a.makeRequest(a.ctx, resourceID)
+		var authErr *AzureMonitorAuthError
+		if errors.As(err, &authErr) {
+			return fmt.Errorf("authentication failed for resource %q, check the credentials: %v", resourceID, authErr.Err)
+		}
+		if err != nil {
+			return fmt.Errorf("error connecting to azure monitor for resource %q: %v", resourceID, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("authentication failed for resource %q with status %d, check the credentials and their role assignments",
+				resourceID, resp.StatusCode)
+		case resp.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("resource %q not found, check the resource id and subscription", resourceID)
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			return fmt.Errorf("request for resource %q failed with status %d", resourceID, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// prepareRequest creates the request for the given URL and adds the bearer
+// token of the authorizer, refreshing it if needed
+func (a *AzureMonitor) prepareRequest(ctx context.Context, requestURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	if a.UserAgent != "" {
+		req.Header.Set("User-Agent", a.UserAgent)
+	}
+
+	req, err = autorest.Prepare(req, a.authorizer.WithAuthorization())
+	if err != nil {
+		return nil, &AzureMonitorAuthError{Err: err}
+	}
+	return req, nil
+}
+
+func (a *AzureMonitor) doRequest(req *http.Request) (*http.Response, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("request timed out after %s", time.Duration(a.Timeout))
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// isRetryable reports whether a request failed with a transient error, i.e.
+// a transport error or one of the status codes below
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryBackoff returns how long to wait before the next attempt, preferring
+// the delay requested by the server via the Retry-After header. Both are
+// capped at maxRetryInterval so a single resource cannot stall the collection.
+func (a *AzureMonitor) retryBackoff(resp *http.Response, attempt int) time.Duration {
+	if resp != nil {
+		if wait, ok := a.retryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > maxRetryInterval {
+				wait = maxRetryInterval
+			}
+			return wait
+		}
+	}
+
+	wait := a.retryInterval << uint(attempt)
+	if wait > maxRetryInterval || wait <= 0 {
+		wait = maxRetryInterval
+	}
+	return wait
+}
+
+// retryAfter parses the value of a Retry-After header, given either in
+// seconds or as a date, and reports whether it was valid
+func (a *AzureMonitor) retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if wait := t.Sub(a.timeFunc()); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// timespan returns the value of the timespan parameter for a request issued
+// now. Durations are converted to a window ending at the current time.
+func (a *AzureMonitor) timespan() string {
+	if a.StartTime != "" {
+		return a.StartTime + "/" + a.EndTime
+	}
+	if a.timespanDuration == 0 {
+		return a.Timespan
+	}
+	end := a.timeFunc().UTC()
+	start := end.Add(-a.timespanDuration)
+	return start.Format(time.RFC3339) + "/" + end.Format(time.RFC3339)
+}
+
+func (a *AzureMonitor) parseResponse(resp *http.Response, resourceID string) (*AzureMonitorResponse, error) {
+	var monitorResponse AzureMonitorResponse
+	if err := a.decodeResponse(resp, resourceID, &monitorResponse); err != nil {
+		return nil, err
+	}
+	return &monitorResponse, nil
+}
+
+// decodeResponse decodes the JSON body of a 2xx response into v and closes
+// the body; other responses result in an AzureMonitorError. Bodies larger
+// than max_response_body_size are rejected without being buffered entirely,
+// bodies ending early result in an AzureMonitorTruncatedError.
+func (a *AzureMonitor) decodeResponse(resp *http.Response, resourceID string, v interface{}) error {
+	defer resp.Body.Close()
+
+	// Reading past the limit leaves no bytes remaining in the reader.
+	body := &io.LimitedReader{R: resp.Body, N: int64(a.MaxResponseBodySize) + 1}
+	tooLarge := func() error {
+		return fmt.Errorf("response for resource %q exceeds max_response_body_size of %d bytes",
+			resourceID, a.MaxResponseBodySize)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errBody, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("error reading response: %v", err)
+		}
+		if body.N == 0 {
+			return tooLarge()
+		}
+		monitorErr := &AzureMonitorError{
+			StatusCode: resp.StatusCode,
+			Body:       string(errBody),
+			ResourceID: resourceID,
+		}
+		if resp.Request != nil {
+			monitorErr.URL = resp.Request.URL.Redacted()
+		}
+		return monitorErr
+	}
+
+	err := json.NewDecoder(body).Decode(v)
+	if body.N == 0 {
+		return tooLarge()
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		truncatedErr := &AzureMonitorTruncatedError{
+			ResourceID: resourceID,
+			BytesRead:  int64(a.MaxResponseBodySize) + 1 - body.N,
+			Err:        err,
+		}
+		if resp.Request != nil {
+			truncatedErr.URL = resp.Request.URL.Redacted()
+		}
+		return truncatedErr
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	inputs.Add("azure_monitor", func() telegraf.Input {
+		return &AzureMonitor{
+			MaxRetries:           defaultMaxRetries,
+			EnableGzip:           true,
+			CountAsInteger:       true,
+			ValuePrecision:       -1,
+			ValidateDimensions:   true,
+			IncludeResourceIDTag: true,
+			IncludeNamespaceTag:  true,
+			IncludeRegionTag:     true,
+		}
+	})
+}
@@ -0,0 +1,4177 @@
+package azure_monitor
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/plugins/common/proxy"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+const testResourceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account"
+
+const aggregationsResponse = `
+{
+  "cost": 0,
+  "timespan": "2021-05-01T00:00:00Z/2021-05-01T00:01:00Z",
+  "interval": "PT1M",
+  "value": [
+    {
+      "id": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account/providers/Microsoft.Insights/metrics/BlobCapacity",
+      "type": "Microsoft.Insights/metrics",
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "displayDescription": "The amount of storage used by the storage account's Blob service in bytes.",
+      "unit": "Bytes",
+      "timeseries": [
+        {
+          "metadatavalues": [],
+          "data": [
+            {"timeStamp": "2021-05-01T00:00:00Z", "average": 1024, "total": 2048, "minimum": 512, "maximum": 1536, "count": 2}
+          ]
+        }
+      ],
+      "errorCode": "Success"
+    }
+  ],
+  "namespace": "Microsoft.Storage/storageAccounts",
+  "resourceregion": "eastus"
+}
+`
+
+func newTestServer(t *testing.T, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, body)
+		require.NoError(t, err)
+	}))
+}
+
+func newTestPlugin(url string) *AzureMonitor {
+	timeout := defaultTimeout
+	return &AzureMonitor{
+		ResourceId:            testResourceID,
+		Aggregations:          []string{"Average"},
+		ApiVersion:            defaultAPIVersion,
+		MeasurementName:       defaultMeasurementName,
+		MaxConcurrentRequests: defaultMaxConcurrency,
+		MaxResponseBodySize:   config.Size(defaultMaxResponseSize),
+		FieldSeparator:        defaultFieldSeparator,
+		MaxPages:              defaultMaxPages,
+		ValueScale:            1,
+		ValuePrecision:        -1,
+		IncludeResourceIDTag:  true,
+		ValidateDimensions:    true,
+		CountAsInteger:        true,
+		Timeout:               config.Duration(timeout),
+		Log:                   testutil.Logger{},
+		resourceIDs:           []string{testResourceID},
+		timespanDuration:      defaultTimespan,
+		baseURL:               url,
+		authorizer:            autorest.NullAuthorizer{},
+		client:                &http.Client{Timeout: timeout},
+		timeFunc:              time.Now,
+		ctx:                   context.Background(),
+	}
+}
+
+func TestInitValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin *AzureMonitor
+		err    string
+	}{
+		{
+			name:   "missing resource id",
+			plugin: &AzureMonitor{},
+			err:    "resource_id, resource_ids, resource_id_file or resource must be configured",
+		},
+		{
+			name: "unsupported aggregation",
+			plugin: &AzureMonitor{
+				ResourceId:   testResourceID,
+				Aggregations: []string{"Median"},
+			},
+			err: `unsupported aggregation "Median", must be one of Average, Total, Minimum, Maximum, Count`,
+		},
+		{
+			name: "metric name with comma",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Metrics:    []string{"BlobCapacity", "Transactions,Egress"},
+			},
+			err: `invalid metric name "Transactions,Egress", must not contain a comma`,
+		},
+		{
+			name: "malformed api version",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				ApiVersion: "2019-7-1",
+			},
+			err: `invalid api_version "2019-7-1", expected the form YYYY-MM-DD`,
+		},
+		{
+			name: "partial client credentials",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				ClientID:   "client",
+				TenantID:   "tenant",
+			},
+			err: "client_id, client_secret and tenant_id must be configured together",
+		},
+		{
+			name: "resource outside of subscription",
+			plugin: &AzureMonitor{
+				ResourceId:     testResourceID,
+				SubscriptionID: "11111111-1111-1111-1111-111111111111",
+			},
+			err: `resource "` + testResourceID + `" is not in subscription "11111111-1111-1111-1111-111111111111"`,
+		},
+		{
+			name: "malformed timespan duration",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Timespan:   "5m",
+			},
+			err: `invalid timespan "5m", expected an ISO 8601 duration or a start/end pair`,
+		},
+		{
+			name: "timespan end before start",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Timespan:   "2021-05-01T01:00:00Z/2021-05-01T00:00:00Z",
+			},
+			err: `invalid timespan "2021-05-01T01:00:00Z/2021-05-01T00:00:00Z": start time must precede end time`,
+		},
+		{
+			name: "blank measurement name",
+			plugin: &AzureMonitor{
+				ResourceId:      testResourceID,
+				MeasurementName: "  ",
+			},
+			err: "measurement_name must not be blank",
+		},
+		{
+			name: "measurement name with newline",
+			plugin: &AzureMonitor{
+				ResourceId:      testResourceID,
+				MeasurementName: "azure\nmonitor",
+			},
+			err: `invalid measurement_name "azure\nmonitor", must not contain control characters`,
+		},
+		{
+			name: "unknown cloud",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				CloudName:  "AzureMoon",
+			},
+			err: `unknown cloud_name "AzureMoon"`,
+		},
+		{
+			name: "namespace with unsafe characters",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Namespace:  "guest&metricnames=x",
+			},
+			err: `invalid namespace "guest&metricnames=x", only letters, digits, '.', '_', '-' and '/' are allowed`,
+		},
+		{
+			name: "unknown auth method",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				AuthMethod: "password",
+			},
+			err: `unknown auth_method "password", must be "env" or "msi"`,
+		},
+		{
+			name: "client secret with msi",
+			plugin: &AzureMonitor{
+				ResourceId:   testResourceID,
+				AuthMethod:   "msi",
+				ClientID:     "client",
+				ClientSecret: "secret",
+			},
+			err: "client_secret and tenant_id are not used with msi authentication",
+		},
+		{
+			name: "malformed proxy url",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				HTTPProxy:  proxy.HTTPProxy{HTTPProxyURL: "://proxy"},
+			},
+			err: `error parsing proxy url "://proxy": parse "://proxy": missing protocol scheme`,
+		},
+		{
+			name: "resource id with trailing slash",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID + "/",
+			},
+			err: `invalid resource id "` + testResourceID + `/": must not end with a slash`,
+		},
+		{
+			name: "resource id without subscription guid",
+			plugin: &AzureMonitor{
+				ResourceId: "/subscriptions/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account",
+			},
+			err: `invalid resource id "/subscriptions/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account": segment 2 is "resourceGroups", expected a subscription GUID`,
+		},
+		{
+			name: "resource id without resource name",
+			plugin: &AzureMonitor{
+				ResourceIds: []string{"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts"},
+			},
+			err: `invalid resource id "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts": expected pairs of resource type and name after the provider namespace`,
+		},
+		{
+			name: "negative token refresh buffer",
+			plugin: &AzureMonitor{
+				ResourceId:         testResourceID,
+				TokenRefreshBuffer: config.Duration(-time.Minute),
+			},
+			err: "token_refresh_buffer must not be negative",
+		},
+		{
+			name: "malformed aggregation interval",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Interval:   "5m",
+			},
+			err: `invalid aggregation_interval "5m", expected an ISO 8601 duration`,
+		},
+		{
+			name: "negative top",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Top:        -1,
+			},
+			err: "top must be positive",
+		},
+		{
+			name: "order by unrequested aggregation",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				OrderBy:    "Total desc",
+			},
+			err: `invalid order_by "Total desc": aggregation "Total" is not requested`,
+		},
+		{
+			name: "order by unknown direction",
+			plugin: &AzureMonitor{
+				ResourceId:   testResourceID,
+				Aggregations: []string{"Average", "Total"},
+				OrderBy:      "Total down",
+			},
+			err: `invalid order_by "Total down": unknown direction "down", must be "asc" or "desc"`,
+		},
+		{
+			name: "order by without direction",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				OrderBy:    "Average",
+			},
+			err: `invalid order_by "Average": expected an aggregation followed by "asc" or "desc"`,
+		},
+		{
+			name: "start time without end time",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				StartTime:  "2021-05-01T00:00:00Z",
+			},
+			err: "start_time and end_time must be configured together",
+		},
+		{
+			name: "start time with timespan",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Timespan:   "PT1H",
+				StartTime:  "2021-05-01T00:00:00Z",
+				EndTime:    "2021-05-02T00:00:00Z",
+			},
+			err: "timespan and start_time/end_time must not be configured together",
+		},
+		{
+			name: "end time before start time",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				StartTime:  "2021-05-02T00:00:00Z",
+				EndTime:    "2021-05-01T00:00:00Z",
+			},
+			err: "invalid start_time/end_time: start time must precede end time",
+		},
+		{
+			name: "malformed start time",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				StartTime:  "yesterday",
+				EndTime:    "2021-05-01T00:00:00Z",
+			},
+			err: `invalid start_time/end_time: invalid start time: parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`,
+		},
+		{
+			name: "negative requests per second",
+			plugin: &AzureMonitor{
+				ResourceId:        testResourceID,
+				RequestsPerSecond: -1,
+			},
+			err: "requests_per_second must not be negative",
+		},
+		{
+			name: "resource table without resource id",
+			plugin: &AzureMonitor{
+				Resources: []ResourceConfig{{Metrics: []string{"BlobCapacity"}}},
+			},
+			err: "resource_id must be configured for each resource",
+		},
+		{
+			name: "resource table duplicating resource id",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				Resources:  []ResourceConfig{{ResourceID: testResourceID}},
+			},
+			err: `resource "` + testResourceID + `" is configured more than once`,
+		},
+		{
+			name: "resource table with unsupported aggregation",
+			plugin: &AzureMonitor{
+				Resources: []ResourceConfig{{ResourceID: testResourceID, Aggregations: []string{"Median"}}},
+			},
+			err: `resource "` + testResourceID + `": unsupported aggregation "Median", must be one of Average, Total, Minimum, Maximum, Count`,
+		},
+		{
+			name: "resource table without order by aggregation",
+			plugin: &AzureMonitor{
+				Aggregations: []string{"Total"},
+				OrderBy:      "Total desc",
+				Resources:    []ResourceConfig{{ResourceID: testResourceID, Aggregations: []string{"Average"}}},
+			},
+			err: `resource "` + testResourceID + `": invalid order_by "Total desc": aggregation "Total" is not requested`,
+		},
+		{
+			name: "negative circuit breaker threshold",
+			plugin: &AzureMonitor{
+				ResourceId:              testResourceID,
+				CircuitBreakerThreshold: -1,
+			},
+			err: "circuit_breaker_threshold must not be negative",
+		},
+		{
+			name: "auth file with client secret",
+			plugin: &AzureMonitor{
+				ResourceId:   testResourceID,
+				ClientID:     "client",
+				ClientSecret: "secret",
+				TenantID:     "tenant",
+				AuthFile:     "azure.auth",
+			},
+			err: "auth_file cannot be used together with client_id, client_secret and tenant_id",
+		},
+		{
+			name: "auth file with msi",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				AuthMethod: "msi",
+				AuthFile:   "azure.auth",
+			},
+			err: "auth_file is not used with msi authentication",
+		},
+		{
+			name: "client secret with client certificate",
+			plugin: &AzureMonitor{
+				ResourceId:     testResourceID,
+				ClientID:       "client",
+				ClientSecret:   "secret",
+				ClientCertPath: "testdata/client.pfx",
+				TenantID:       "tenant",
+			},
+			err: "client_secret and client_cert_path cannot be used together",
+		},
+		{
+			name: "client certificate without tenant",
+			plugin: &AzureMonitor{
+				ResourceId:     testResourceID,
+				ClientID:       "client",
+				ClientCertPath: "testdata/client.pfx",
+			},
+			err: "client_id, client_cert_path and tenant_id must be configured together",
+		},
+		{
+			name: "client certificate password without certificate",
+			plugin: &AzureMonitor{
+				ResourceId:         testResourceID,
+				ClientCertPassword: "telegraf",
+			},
+			err: "client_cert_password requires client_cert_path",
+		},
+		{
+			name: "missing client certificate",
+			plugin: &AzureMonitor{
+				ResourceId:     testResourceID,
+				ClientID:       "client",
+				ClientCertPath: "testdata/missing.pfx",
+				TenantID:       "tenant",
+			},
+			err: "error reading client_cert_path: open testdata/missing.pfx: no such file or directory",
+		},
+		{
+			name: "negative max response body size",
+			plugin: &AzureMonitor{
+				ResourceId:          testResourceID,
+				MaxResponseBodySize: -1,
+			},
+			err: "max_response_body_size must not be negative",
+		},
+		{
+			name: "invalid metric include pattern",
+			plugin: &AzureMonitor{
+				ResourceId:    testResourceID,
+				MetricInclude: []string{"Transactions["},
+			},
+			err: "error compiling metric_include or metric_exclude: unexpected end of input",
+		},
+		{
+			name: "field separator with control characters",
+			plugin: &AzureMonitor{
+				ResourceId:     testResourceID,
+				FieldSeparator: "\n",
+			},
+			err: `invalid field_separator "\n", must not contain control characters`,
+		},
+		{
+			name: "negative max pages",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				MaxPages:   -1,
+			},
+			err: "max_pages must not be negative",
+		},
+		{
+			name: "zero metric scale",
+			plugin: &AzureMonitor{
+				ResourceId:  testResourceID,
+				MetricScale: map[string]float64{"UsedCapacity": 0},
+			},
+			err: `metric_scale of "UsedCapacity" must not be zero`,
+		},
+		{
+			name: "management endpoint without https",
+			plugin: &AzureMonitor{
+				ResourceId:         testResourceID,
+				ManagementEndpoint: "http://westeurope.management.azure.com",
+			},
+			err: `invalid management_endpoint "http://westeurope.management.azure.com", must be an https URL`,
+		},
+		{
+			name: "malformed management endpoint",
+			plugin: &AzureMonitor{
+				ResourceId:         testResourceID,
+				ManagementEndpoint: "westeurope.management.azure.com",
+			},
+			err: `invalid management_endpoint "westeurope.management.azure.com", must be an https URL`,
+		},
+		{
+			name: "negative dedupe cache size",
+			plugin: &AzureMonitor{
+				ResourceId:      testResourceID,
+				DedupeCacheSize: -1,
+			},
+			err: "dedupe_cache_size must not be negative",
+		},
+		{
+			name: "negative max idle conns",
+			plugin: &AzureMonitor{
+				ResourceId:   testResourceID,
+				MaxIdleConns: -1,
+			},
+			err: "max_idle_conns must not be negative",
+		},
+		{
+			name: "negative max idle conns per host",
+			plugin: &AzureMonitor{
+				ResourceId:          testResourceID,
+				MaxIdleConnsPerHost: -1,
+			},
+			err: "max_idle_conns_per_host must not be negative",
+		},
+		{
+			name: "wide rows without latest only",
+			plugin: &AzureMonitor{
+				ResourceId: testResourceID,
+				WideRows:   true,
+			},
+			err: "wide_rows requires latest_only",
+		},
+		{
+			name: "token audience without https",
+			plugin: &AzureMonitor{
+				ResourceId:    testResourceID,
+				TokenAudience: "management.azure.com",
+			},
+			err: `invalid token_audience "management.azure.com", must be an https URL`,
+		},
+		{
+			name: "blank field name map name",
+			plugin: &AzureMonitor{
+				ResourceId:   testResourceID,
+				FieldNameMap: map[string]string{"SuccessServerLatency": " "},
+			},
+			err: `invalid field_name_map name " " of "SuccessServerLatency", must not be blank or contain control characters`,
+		},
+		{
+			name: "invalid empty series policy",
+			plugin: &AzureMonitor{
+				ResourceId:        testResourceID,
+				EmptySeriesPolicy: "fill",
+			},
+			err: `invalid empty_series_policy "fill", must be one of skip, zero, warn`,
+		},
+		{
+			name: "fallback resource id without resource id",
+			plugin: &AzureMonitor{
+				ResourceIds:        []string{testResourceID},
+				FallbackResourceId: testResourceID + "2",
+			},
+			err: "fallback_resource_id requires resource_id",
+		},
+		{
+			name: "invalid fallback resource id",
+			plugin: &AzureMonitor{
+				ResourceId:         testResourceID,
+				FallbackResourceId: "account",
+			},
+			err: `invalid fallback_resource_id "account": must start with a slash`,
+		},
+		{
+			name: "fallback resource id also configured",
+			plugin: &AzureMonitor{
+				ResourceId:         testResourceID,
+				ResourceIds:        []string{testResourceID + "2"},
+				FallbackResourceId: testResourceID + "2",
+			},
+			err: `fallback_resource_id "` + testResourceID + `2" is also configured as a resource`,
+		},
+		{
+			name: "fallback resource id outside of subscription",
+			plugin: &AzureMonitor{
+				ResourceId:         testResourceID,
+				SubscriptionID:     "00000000-0000-0000-0000-000000000000",
+				FallbackResourceId: "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account",
+			},
+			err: `invalid fallback_resource_id "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account": not in subscription "00000000-0000-0000-0000-000000000000"`,
+		},
+		{
+			name: "value precision too large",
+			plugin: &AzureMonitor{
+				ResourceId:     testResourceID,
+				ValuePrecision: 16,
+			},
+			err: "value_precision must not exceed 15",
+		},
+		{
+			name: "aggregation as tag with field separator",
+			plugin: &AzureMonitor{
+				ResourceId:       testResourceID,
+				AggregationAsTag: true,
+				FieldSeparator:   ".",
+			},
+			err: "aggregation_as_tag and field_separator must not be configured together",
+		},
+		{
+			name: "negative serve stale for",
+			plugin: &AzureMonitor{
+				ResourceId:    testResourceID,
+				ServeStaleFor: config.Duration(-time.Minute),
+			},
+			err: "serve_stale_for must not be negative",
+		},
+		{
+			name: "unsupported response field tag",
+			plugin: &AzureMonitor{
+				ResourceId:        testResourceID,
+				ResponseFieldTags: []string{"unit", "name"},
+			},
+			err: `unsupported response_field_tags entry "name", must be one of type, unit, id, display_description`,
+		},
+		{
+			name: "invalid duplicate policy",
+			plugin: &AzureMonitor{
+				ResourceId:      testResourceID,
+				DuplicatePolicy: "merge",
+			},
+			err: `invalid duplicate_policy "merge", must be one of overwrite, prefix, error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.plugin.Log = testutil.Logger{}
+			require.EqualError(t, tt.plugin.Init(), tt.err)
+		})
+	}
+}
+
+func TestGatherAggregations(t *testing.T) {
+	tests := []struct {
+		name         string
+		aggregations []string
+		query        string
+		fields       map[string]interface{}
+	}{
+		{
+			name:         "average only keeps plain field names",
+			aggregations: []string{"Average"},
+			query:        "Average",
+			fields: map[string]interface{}{
+				"BlobCapacity": float64(1024),
+			},
+		},
+		{
+			name:         "multiple aggregations are suffixed",
+			aggregations: []string{"Average", "Total", "Minimum", "Maximum", "Count"},
+			query:        "Average,Total,Minimum,Maximum,Count",
+			fields: map[string]interface{}{
+				"BlobCapacity_average": float64(1024),
+				"BlobCapacity_total":   float64(2048),
+				"BlobCapacity_minimum": float64(512),
+				"BlobCapacity_maximum": float64(1536),
+				"BlobCapacity_count":   int64(2),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query, apiVersion string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query().Get("aggregation")
+				apiVersion = r.URL.Query().Get("api-version")
+				_, err := fmt.Fprint(w, aggregationsResponse)
+				require.NoError(t, err)
+			}))
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.Aggregations = tt.aggregations
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.Equal(t, tt.query, query)
+			require.Equal(t, "2018-01-01", apiVersion)
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{
+						"resource_id": testResourceID,
+					},
+					tt.fields,
+					time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+		})
+	}
+}
+
+func TestGatherErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, err := fmt.Fprint(w, `{"code":"ResourceNotFound"}`)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	requireMonitorError(t, acc.FirstError(), ts.URL, testResourceID, http.StatusNotFound, `{"code":"ResourceNotFound"}`)
+	require.Len(t, acc.GetTelegrafMetrics(), 0)
+}
+
+func requireMonitorError(t *testing.T, err error, baseURL, resourceID string, status int, body string) {
+	t.Helper()
+
+	var monitorErr *AzureMonitorError
+	require.ErrorAs(t, err, &monitorErr)
+	require.Equal(t, status, monitorErr.StatusCode)
+	require.Equal(t, body, monitorErr.Body)
+	require.Equal(t, resourceID, monitorErr.ResourceID)
+	require.True(t, strings.HasPrefix(monitorErr.URL, baseURL+resourceID+"/providers/microsoft.insights/metrics?"), monitorErr.URL)
+	require.EqualError(t, err, fmt.Sprintf("azure monitor responded with status %d for resource %q (%s): %s",
+		status, resourceID, monitorErr.URL, body))
+}
+
+func TestGatherMalformedResponse(t *testing.T) {
+	ts := newTestServer(t, `{"value": [`)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	var truncatedErr *AzureMonitorTruncatedError
+	require.ErrorAs(t, acc.FirstError(), &truncatedErr)
+	require.Equal(t, testResourceID, truncatedErr.ResourceID)
+	require.Equal(t, int64(len(`{"value": [`)), truncatedErr.BytesRead)
+	require.ErrorIs(t, acc.FirstError(), io.ErrUnexpectedEOF)
+	require.EqualError(t, acc.FirstError(), fmt.Sprintf("response for resource %q (%s) was truncated after 11 bytes: unexpected EOF",
+		testResourceID, truncatedErr.URL))
+	require.Len(t, acc.GetTelegrafMetrics(), 0)
+}
+
+func TestGatherEmptyResponseBody(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	var truncatedErr *AzureMonitorTruncatedError
+	require.ErrorAs(t, acc.FirstError(), &truncatedErr)
+	require.Equal(t, int64(0), truncatedErr.BytesRead)
+	require.ErrorIs(t, acc.FirstError(), io.EOF)
+}
+
+func TestGatherInvalidJSONResponse(t *testing.T) {
+	ts := newTestServer(t, `{"value": [}`)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.EqualError(t, acc.FirstError(), "error decoding response: invalid character '}' looking for beginning of value")
+}
+
+func TestInitDeduplicatesResourceIDs(t *testing.T) {
+	other := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/other"
+	plugin := &AzureMonitor{
+		ResourceId:  testResourceID,
+		ResourceIds: []string{other, testResourceID, other},
+		Log:         testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	require.Equal(t, []string{testResourceID, other}, plugin.resourceIDs)
+}
+
+func TestGatherMultipleResources(t *testing.T) {
+	other := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/other"
+
+	var mu sync.Mutex
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.resourceIDs = []string{testResourceID, other}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.ElementsMatch(t, []string{
+		testResourceID + "/providers/microsoft.insights/metrics",
+		other + "/providers/microsoft.insights/metrics",
+	}, paths)
+
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": float64(1024)},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": other},
+			map[string]interface{}{"BlobCapacity": float64(1024)},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherDimensions(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "Transactions", "localizedValue": "Transactions"},
+      "unit": "Count",
+      "timeseries": [
+        {
+          "metadatavalues": [
+            {"name": {"value": "apiname", "localizedValue": "API name"}, "value": "GetBlob"},
+            {"name": {"value": "responsetype", "localizedValue": "Response type"}, "value": "Success"}
+          ],
+          "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 10}]
+        },
+        {
+          "metadatavalues": [
+            {"name": {"value": "apiname", "localizedValue": "API name"}, "value": "PutBlob"},
+            {"name": {"value": "responsetype", "localizedValue": "Response type"}, "value": "Success"}
+          ],
+          "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 20}]
+        }
+      ],
+      "errorCode": "Success"
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{
+				"resource_id":  testResourceID,
+				"apiname":      "GetBlob",
+				"responsetype": "Success",
+			},
+			map[string]interface{}{"Transactions": float64(10)},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{
+				"resource_id":  testResourceID,
+				"apiname":      "PutBlob",
+				"responsetype": "Success",
+			},
+			map[string]interface{}{"Transactions": float64(20)},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestMetricNamesQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []string
+		present bool
+		query   string
+	}{
+		{
+			name:    "all metrics",
+			present: false,
+		},
+		{
+			name:    "selected metrics",
+			metrics: []string{"BlobCapacity", "Transactions"},
+			present: true,
+			query:   "BlobCapacity,Transactions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query url.Values
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				_, err := fmt.Fprint(w, aggregationsResponse)
+				require.NoError(t, err)
+			}))
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.Metrics = tt.metrics
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+
+			_, present := query["metricnames"]
+			require.Equal(t, tt.present, present)
+			require.Equal(t, tt.query, query.Get("metricnames"))
+		})
+	}
+}
+
+func TestNamespaceQuery(t *testing.T) {
+	var query url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	_, present := query["metricnamespace"]
+	require.False(t, present)
+
+	plugin.Namespace = "azure.vm.windows.guest"
+	require.NoError(t, plugin.Gather(&acc))
+	require.Equal(t, "azure.vm.windows.guest", query.Get("metricnamespace"))
+}
+
+func TestTimespanQuery(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		timespan  string
+		startTime string
+		endTime   string
+		expected  string
+	}{
+		{
+			name:     "default",
+			expected: "2021-05-01T11:59:00Z/2021-05-01T12:00:00Z",
+		},
+		{
+			name:     "duration",
+			timespan: "PT1H30M",
+			expected: "2021-05-01T10:30:00Z/2021-05-01T12:00:00Z",
+		},
+		{
+			name:     "duration in days",
+			timespan: "P1D",
+			expected: "2021-04-30T12:00:00Z/2021-05-01T12:00:00Z",
+		},
+		{
+			name:     "start and end",
+			timespan: "2021-04-01T00:00:00Z/2021-04-02T00:00:00Z",
+			expected: "2021-04-01T00:00:00Z/2021-04-02T00:00:00Z",
+		},
+		{
+			name:      "start and end time",
+			startTime: "2021-04-30T00:00:00Z",
+			endTime:   "2021-05-01T00:00:00Z",
+			expected:  "2021-04-30T00:00:00Z/2021-05-01T00:00:00Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var timespan string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				timespan = r.URL.Query().Get("timespan")
+				_, err := fmt.Fprint(w, aggregationsResponse)
+				require.NoError(t, err)
+			}))
+			defer ts.Close()
+
+			plugin := &AzureMonitor{
+				ResourceId: testResourceID,
+				Timespan:   tt.timespan,
+				StartTime:  tt.startTime,
+				EndTime:    tt.endTime,
+				Log:        testutil.Logger{},
+				baseURL:    ts.URL,
+				timeFunc:   func() time.Time { return now },
+			}
+			require.NoError(t, plugin.Init())
+			plugin.authorizer = autorest.NullAuthorizer{}
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.Equal(t, tt.expected, timespan)
+		})
+	}
+}
+
+func TestGatherTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		Timeout:    config.Duration(50 * time.Millisecond),
+		Log:        testutil.Logger{},
+		baseURL:    ts.URL,
+	}
+	require.NoError(t, plugin.Init())
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.EqualError(t, acc.FirstError(), "request timed out after 50ms")
+}
+
+func TestGatherRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		status   int
+	}{
+		{
+			name:     "transient errors are retried",
+			statuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK},
+			requests: 3,
+		},
+		{
+			name:     "retries are exhausted",
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			requests: 3,
+			status:   http.StatusBadGateway,
+		},
+		{
+			name:     "permanent errors fail fast",
+			statuses: []int{http.StatusForbidden, http.StatusOK},
+			requests: 1,
+			status:   http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[requests]
+				requests++
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					_, err := fmt.Fprint(w, "error")
+					require.NoError(t, err)
+					return
+				}
+				_, err := fmt.Fprint(w, aggregationsResponse)
+				require.NoError(t, err)
+			}))
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.MaxRetries = 2
+			plugin.retryInterval = time.Millisecond
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			if tt.status == 0 {
+				require.NoError(t, acc.FirstError())
+				require.Len(t, acc.GetTelegrafMetrics(), 1)
+			} else {
+				requireMonitorError(t, acc.FirstError(), ts.URL, testResourceID, tt.status, "error")
+			}
+			require.Equal(t, tt.requests, requests)
+		})
+	}
+}
+
+// failingAuthorizer fails to authorize every request, counting the attempts
+type failingAuthorizer struct {
+	attempts int
+}
+
+func (f *failingAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			f.attempts++
+			return r, errors.New("invalid client secret")
+		})
+	}
+}
+
+func TestGatherAuthErrorNotRetried(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+
+	authorizer := &failingAuthorizer{}
+	plugin := newTestPlugin(ts.URL)
+	plugin.MaxRetries = 3
+	plugin.retryInterval = time.Millisecond
+	plugin.authorizer = authorizer
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	var authErr *AzureMonitorAuthError
+	require.ErrorAs(t, acc.FirstError(), &authErr)
+	require.Equal(t, 1, authorizer.attempts)
+	require.Equal(t, 0, requests)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := &AzureMonitor{
+		retryInterval: time.Second,
+		timeFunc:      func() time.Time { return now },
+	}
+
+	header := func(retryAfter string) *http.Response {
+		resp := &http.Response{Header: make(http.Header)}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	require.Equal(t, time.Second, plugin.retryBackoff(nil, 0))
+	require.Equal(t, 4*time.Second, plugin.retryBackoff(header(""), 2))
+	require.Equal(t, maxRetryInterval, plugin.retryBackoff(header(""), 10))
+	require.Equal(t, 7*time.Second, plugin.retryBackoff(header("7"), 0))
+	require.Equal(t, 10*time.Second, plugin.retryBackoff(header("Sat, 01 May 2021 12:00:10 GMT"), 0))
+	require.Equal(t, maxRetryInterval, plugin.retryBackoff(header("3600"), 0))
+	require.Equal(t, maxRetryInterval, plugin.retryBackoff(header("Sat, 01 May 2021 13:00:00 GMT"), 0))
+}
+
+func TestGatherReportCost(t *testing.T) {
+	ts := newTestServer(t, `{"cost": 42, "value": []}`)
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.ReportCost = true
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor_cost",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"cost": 42},
+			now,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherErrorCode(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}],
+      "errorCode": "Success"
+    },
+    {
+      "name": {"value": "BlobCount", "localizedValue": "Blob Count"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 0}]}],
+      "errorCode": "InternalError"
+    }
+  ]
+}
+`
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		tagErrorCode bool
+		expected     []telegraf.Metric
+	}{
+		{
+			name: "failed metrics are skipped",
+			expected: []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID},
+					map[string]interface{}{"BlobCapacity": float64(1024)},
+					timestamp,
+				),
+			},
+		},
+		{
+			name:         "failed metrics are tagged",
+			tagErrorCode: true,
+			expected: []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID},
+					map[string]interface{}{"BlobCapacity": float64(1024)},
+					timestamp,
+				),
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{
+						"resource_id": testResourceID,
+						"error_code":  "InternalError",
+					},
+					map[string]interface{}{"BlobCount": float64(0)},
+					timestamp,
+				),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.TagErrorCode = tt.tagErrorCode
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			testutil.RequireMetricsEqual(t, tt.expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+		})
+	}
+}
+
+func TestGatherMeasurementName(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.MeasurementName = "azure_storage"
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.True(t, acc.HasMeasurement("azure_storage"))
+	require.False(t, acc.HasMeasurement("azure_monitor"))
+}
+
+func TestGatherNamespaceTag(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeNamespaceTag = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.Equal(t, "Microsoft.Storage/storageAccounts", acc.TagValue("azure_monitor", "namespace"))
+
+	acc.ClearMetrics()
+	plugin.IncludeNamespaceTag = false
+	require.NoError(t, plugin.Gather(&acc))
+	require.False(t, acc.HasTag("azure_monitor", "namespace"))
+}
+
+func TestGatherUnitTag(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "unit": "Bytes",
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}]
+    },
+    {
+      "name": {"value": "BlobCount", "localizedValue": "Blob Count"},
+      "unit": "Count",
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 3}]}]
+    },
+    {
+      "name": {"value": "ContainerCount", "localizedValue": "Blob Container Count"},
+      "unit": "Count",
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1}]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeUnitTag = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{
+				"resource_id": testResourceID,
+				"unit":        "Bytes",
+			},
+			map[string]interface{}{"BlobCapacity": float64(1024)},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{
+				"resource_id": testResourceID,
+				"unit":        "Count",
+			},
+			map[string]interface{}{
+				"BlobCount":      float64(3),
+				"ContainerCount": float64(1),
+			},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherLocalizedNames(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Capacité des blobs"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}]
+    },
+    {
+      "name": {"value": "BlobCount", "localizedValue": ""},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 3}]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.UseLocalizedNames = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{
+				"Capacité_des_blobs": float64(1024),
+				"BlobCount":          float64(3),
+			},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestInitCloudEndpoint(t *testing.T) {
+	tests := []struct {
+		cloud    string
+		expected string
+	}{
+		{cloud: "", expected: "https://management.azure.com"},
+		{cloud: "AzurePublic", expected: "https://management.azure.com"},
+		{cloud: "AzureUSGovernment", expected: "https://management.usgovcloudapi.net"},
+		{cloud: "AzureChina", expected: "https://management.chinacloudapi.cn"},
+		{cloud: "AzureGermany", expected: "https://management.microsoftazure.de"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cloud, func(t *testing.T) {
+			plugin := &AzureMonitor{
+				ResourceId: testResourceID,
+				CloudName:  tt.cloud,
+				Log:        testutil.Logger{},
+			}
+			require.NoError(t, plugin.Init())
+			require.Equal(t, tt.expected, plugin.baseURL)
+		})
+	}
+}
+
+func TestGatherRegionTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		region   string
+		expected map[string]string
+	}{
+		{
+			name:   "region present",
+			region: "eastus",
+			expected: map[string]string{
+				"resource_id": testResourceID,
+				"region":      "eastus",
+			},
+		},
+		{
+			name:   "region absent",
+			region: "",
+			expected: map[string]string{
+				"resource_id": testResourceID,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := fmt.Sprintf(`
+{
+  "resourceregion": %q,
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}]
+    }
+  ]
+}
+`, tt.region)
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.IncludeRegionTag = true
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					tt.expected,
+					map[string]interface{}{"BlobCapacity": float64(1024)},
+					time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+		})
+	}
+}
+
+func TestGatherInvalidTimestamp(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [
+        {
+          "data": [
+            {"timeStamp": "yesterday", "average": 512},
+            {"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}
+          ]
+        }
+      ]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": float64(1024)},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherMissingAverage(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [
+        {
+          "data": [
+            {"timeStamp": "2021-05-01T00:00:00Z"},
+            {"timeStamp": "2021-05-01T00:01:00Z", "average": 1024}
+          ]
+        }
+      ]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": float64(1024)},
+			time.Date(2021, 5, 1, 0, 1, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestInitMSI(t *testing.T) {
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		AuthMethod: "msi",
+		ClientID:   "00000000-0000-0000-0000-000000000001",
+		Log:        testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	require.IsType(t, &tokenAuthorizer{}, plugin.authorizer)
+}
+
+func TestGatherConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.MaxConcurrentRequests = 2
+	plugin.resourceIDs = nil
+	for i := 0; i < 6; i++ {
+		plugin.resourceIDs = append(plugin.resourceIDs, fmt.Sprintf("%s%d", testResourceID, i))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 6)
+	require.Equal(t, 2, peak)
+}
+
+func TestGatherPartialFailure(t *testing.T) {
+	missing := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/missing"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, missing) {
+			w.WriteHeader(http.StatusNotFound)
+			_, err := fmt.Fprint(w, `{"code":"ResourceNotFound"}`)
+			require.NoError(t, err)
+			return
+		}
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.resourceIDs = []string{missing, testResourceID}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.Len(t, acc.Errors, 1)
+	requireMonitorError(t, acc.Errors[0], ts.URL, missing, http.StatusNotFound, `{"code":"ResourceNotFound"}`)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": float64(1024)},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca, 0600))
+
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		AuthMethod: "msi",
+		Log:        testutil.Logger{},
+	}
+	plugin.TLSCA = caFile
+	require.NoError(t, plugin.Init())
+	plugin.baseURL = ts.URL
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+}
+
+func TestGatherTLSUntrusted(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		AuthMethod: "msi",
+		MaxRetries: 0,
+		Log:        testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	plugin.baseURL = ts.URL
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.Error(t, acc.FirstError())
+
+	plugin.InsecureSkipVerify = true
+	require.NoError(t, plugin.Init())
+	plugin.baseURL = ts.URL
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	acc = testutil.Accumulator{}
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+}
+
+func TestGatherProxy(t *testing.T) {
+	var proxied bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.Host == "monitor.example.com"
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		AuthMethod: "msi",
+		HTTPProxy:  proxy.HTTPProxy{HTTPProxyURL: ts.URL},
+		Log:        testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	plugin.baseURL = "http://monitor.example.com"
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.True(t, proxied)
+}
+
+func TestValidateResourceID(t *testing.T) {
+	valid := []string{
+		testResourceID,
+		"/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+		"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account/blobServices/default",
+		"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Sql/servers/server/databases/db",
+	}
+	for _, id := range valid {
+		require.NoError(t, validateResourceID(id), id)
+	}
+
+	require.EqualError(t, validateResourceID("subscriptions/00000000-0000-0000-0000-000000000000"), "must start with a slash")
+	require.EqualError(t, validateResourceID("/subscriptions/00000000-0000-0000-0000-000000000000"), `missing "resourceGroups"`)
+	require.EqualError(t, validateResourceID("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups//providers/Microsoft.Storage/storageAccounts/account"), `segment 4 is "", expected a resource group name`)
+}
+
+type fakeTokenSource struct {
+	now       func() time.Time
+	lifetime  time.Duration
+	token     adal.Token
+	refreshes int
+}
+
+func (f *fakeTokenSource) Token() adal.Token {
+	return f.token
+}
+
+func (f *fakeTokenSource) Refresh() error {
+	f.refreshes++
+	f.token = adal.Token{
+		AccessToken: "token" + strconv.Itoa(f.refreshes),
+		ExpiresOn:   json.Number(strconv.FormatInt(f.now().Add(f.lifetime).Unix(), 10)),
+	}
+	return nil
+}
+
+func TestGatherTokenRefresh(t *testing.T) {
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	timeFunc := func() time.Time { return now }
+	source := &fakeTokenSource{now: timeFunc, lifetime: time.Hour}
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.timeFunc = timeFunc
+	plugin.authorizer = &tokenAuthorizer{
+		source:        source,
+		refreshBuffer: defaultRefreshBuffer,
+		timeFunc:      timeFunc,
+	}
+
+	gather := func() {
+		var acc testutil.Accumulator
+		require.NoError(t, plugin.Gather(&acc))
+		require.NoError(t, acc.FirstError())
+	}
+
+	gather()
+	require.Equal(t, 1, source.refreshes)
+	require.Equal(t, "Bearer token1", authorization)
+
+	now = now.Add(50 * time.Minute)
+	gather()
+	require.Equal(t, 1, source.refreshes)
+	require.Equal(t, "Bearer token1", authorization)
+
+	now = now.Add(6 * time.Minute)
+	gather()
+	require.Equal(t, 2, source.refreshes)
+	require.Equal(t, "Bearer token2", authorization)
+}
+
+func TestTestConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+		},
+		{
+			name:   "unauthorized",
+			status: http.StatusUnauthorized,
+			err:    `authentication failed for resource "` + testResourceID + `" with status 401, check the credentials and their role assignments`,
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+			err:    `authentication failed for resource "` + testResourceID + `" with status 403, check the credentials and their role assignments`,
+		},
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			err:    `resource "` + testResourceID + `" not found, check the resource id and subscription`,
+		},
+		{
+			name:   "bad request",
+			status: http.StatusBadRequest,
+			err:    `request for resource "` + testResourceID + `" failed with status 400`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			err := plugin.TestConnection()
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTestConnectionNetworkError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	err := plugin.TestConnection()
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), `error connecting to azure monitor for resource "`+testResourceID+`"`), err.Error())
+}
+
+func TestTestConnectionAuthError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.authorizer = &failingAuthorizer{}
+	err := plugin.TestConnection()
+	require.EqualError(t, err, `authentication failed for resource "`+testResourceID+`", check the credentials: invalid client secret`)
+}
+
+func TestGatherReportGatherDuration(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, err := fmt.Fprint(w, `{"value": []}`)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.ReportGatherDuration = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 1)
+	require.Equal(t, "azure_monitor_internal", metrics[0].Name())
+	require.Equal(t, map[string]string{"resource_id": testResourceID}, metrics[0].Tags())
+	duration, ok := metrics[0].GetField("gather_duration_ms")
+	require.True(t, ok)
+	require.GreaterOrEqual(t, duration.(int64), int64(20))
+}
+
+func TestFilterQuery(t *testing.T) {
+	var rawQuery string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQuery = r.URL.RawQuery
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Metrics = []string{"Transactions"}
+	plugin.Filter = "ApiName eq 'GetBlob' and ResponseType eq 'Success&Retry'"
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	require.Contains(t, rawQuery, "%24filter=ApiName+eq+%27GetBlob%27+and+ResponseType+eq+%27Success%26Retry%27")
+	query, err := url.ParseQuery(rawQuery)
+	require.NoError(t, err)
+	require.Equal(t, plugin.Filter, query.Get("$filter"))
+	require.Equal(t, "Transactions", query.Get("metricnames"))
+	_, present := query["validateDimensions"]
+	require.False(t, present)
+
+	plugin.ValidateDimensions = false
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	query, err = url.ParseQuery(rawQuery)
+	require.NoError(t, err)
+	require.Equal(t, "false", query.Get("validateDimensions"))
+}
+
+func TestIntervalQuery(t *testing.T) {
+	var query url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	_, present := query["interval"]
+	require.False(t, present)
+
+	plugin.Interval = "PT5M"
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, "PT5M", query.Get("interval"))
+	_, present = query["AutoAdjustTimegrain"]
+	require.False(t, present)
+
+	plugin.AutoAdjustTimegrain = true
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, "true", query.Get("AutoAdjustTimegrain"))
+}
+
+func TestGatherIntervalTimespanTags(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeIntervalTag = true
+	plugin.IncludeTimespanTag = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{
+				"resource_id": testResourceID,
+				"interval":    "PT1M",
+				"timespan":    "2021-05-01T00:00:00Z/2021-05-01T00:01:00Z",
+			},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherMetricPerMeasurement(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024, "total": 2048}]}]
+    },
+    {
+      "name": {"value": "BlobCount", "localizedValue": "Blob Count"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 4, "total": 8}]}]
+    }
+  ]
+}
+`
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	tags := map[string]string{"resource_id": testResourceID}
+
+	tests := []struct {
+		name         string
+		aggregations []string
+		expected     []telegraf.Metric
+	}{
+		{
+			name:         "average",
+			aggregations: []string{"Average"},
+			expected: []telegraf.Metric{
+				testutil.MustMetric("azure_monitor_BlobCapacity", tags, map[string]interface{}{"value": 1024.0}, timestamp),
+				testutil.MustMetric("azure_monitor_BlobCount", tags, map[string]interface{}{"value": 4.0}, timestamp),
+			},
+		},
+		{
+			name:         "multiple aggregations",
+			aggregations: []string{"Average", "Total"},
+			expected: []telegraf.Metric{
+				testutil.MustMetric("azure_monitor_BlobCapacity", tags,
+					map[string]interface{}{"value_average": 1024.0, "value_total": 2048.0}, timestamp),
+				testutil.MustMetric("azure_monitor_BlobCount", tags,
+					map[string]interface{}{"value_average": 4.0, "value_total": 8.0}, timestamp),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.MetricPerMeasurement = true
+			plugin.Aggregations = tt.aggregations
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+			testutil.RequireMetricsEqual(t, tt.expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+		})
+	}
+}
+
+func TestTopOrderByQuery(t *testing.T) {
+	var query url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	_, present := query["top"]
+	require.False(t, present)
+
+	plugin.Top = 25
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, "25", query.Get("top"))
+	_, present = query["orderby"]
+	require.False(t, present)
+
+	plugin.OrderBy = "Average desc"
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, "Average desc", query.Get("orderby"))
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"BlobCapacity", "blob_capacity"},
+		{"SuccessE2ELatency", "success_e2e_latency"},
+		{"SuccessServerLatency", "success_server_latency"},
+		{"E2ELatency", "e2e_latency"},
+		{"HTTPRequests", "http_requests"},
+		{"Percentage CPU", "percentage_cpu"},
+		{"Blob_Capacity", "blob_capacity"},
+		{"Ingress", "ingress"},
+		{"UsedCapacity95", "used_capacity95"},
+		{"cpu", "cpu"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, snakeCase(tt.name))
+		})
+	}
+}
+
+func TestGatherNormalizeFieldNames(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "SuccessE2ELatency", "localizedValue": "Success E2E Latency"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 12, "maximum": 40}]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.NormalizeFieldNames = true
+	plugin.Aggregations = []string{"Average", "Maximum"}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{
+				"success_e2e_latency_average": 12.0,
+				"success_e2e_latency_maximum": 40.0,
+			},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherStopCancelsRequests(t *testing.T) {
+	started := make(chan struct{})
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Timeout = config.Duration(time.Minute)
+	plugin.client = &http.Client{Timeout: time.Minute}
+	plugin.MaxRetries = 3
+	plugin.ctx, plugin.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		<-started
+		plugin.Stop()
+	}()
+
+	var acc testutil.Accumulator
+	begin := time.Now()
+	require.NoError(t, plugin.Gather(&acc))
+	require.Less(t, int64(time.Since(begin)), int64(10*time.Second))
+	require.Len(t, acc.Errors, 1)
+	require.True(t, errors.Is(acc.Errors[0], context.Canceled), acc.Errors[0].Error())
+}
+
+func TestStopClosesIdleConnections(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	closed := make(chan struct{})
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			close(closed)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.client = &http.Client{Transport: &http.Transport{}}
+	plugin.ctx, plugin.cancel = context.WithCancel(context.Background())
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	plugin.Stop()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "idle connection was not closed")
+	}
+}
+
+func TestStopWithoutInit(t *testing.T) {
+	plugin := &AzureMonitor{}
+	plugin.Stop()
+
+	// Init failing after creating the client
+	plugin = &AzureMonitor{
+		ResourceId: testResourceID,
+		MaxRetries: -1,
+		Log:        testutil.Logger{},
+	}
+	require.Error(t, plugin.Init())
+	plugin.Stop()
+}
+
+func TestGatherRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.resourceIDs = nil
+	for i := 0; i < 3; i++ {
+		plugin.resourceIDs = append(plugin.resourceIDs, fmt.Sprintf("%s%d", testResourceID, i))
+	}
+	plugin.limiter = rate.NewLimiter(rate.Limit(20), 1)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Len(t, times, 3)
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	require.GreaterOrEqual(t, int64(times[2].Sub(times[0])), int64(90*time.Millisecond))
+}
+
+func TestGatherLatestOnly(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [
+        {
+          "metadatavalues": [{"name": {"value": "tier"}, "value": "Hot"}],
+          "data": [
+            {"timeStamp": "2021-05-01T00:01:00Z", "average": 2048},
+            {"timeStamp": "2021-05-01T00:00:00Z", "average": 1024},
+            {"timeStamp": "2021-05-01T00:03:00Z"},
+            {"timeStamp": "2021-05-01T00:02:00Z", "average": 4096},
+            {"timeStamp": "invalid", "average": 1}
+          ]
+        },
+        {
+          "metadatavalues": [{"name": {"value": "tier"}, "value": "Cool"}],
+          "data": [
+            {"timeStamp": "2021-05-01T00:00:00Z", "average": 512},
+            {"timeStamp": "2021-05-01T00:01:00Z", "average": 256}
+          ]
+        },
+        {
+          "metadatavalues": [{"name": {"value": "tier"}, "value": "Archive"}],
+          "data": [
+            {"timeStamp": "2021-05-01T00:00:00Z"}
+          ]
+        }
+      ]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.LatestOnly = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "tier": "Hot"},
+			map[string]interface{}{"BlobCapacity": 4096.0},
+			time.Date(2021, 5, 1, 0, 2, 0, 0, time.UTC),
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "tier": "Cool"},
+			map[string]interface{}{"BlobCapacity": 256.0},
+			time.Date(2021, 5, 1, 0, 1, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherFieldPrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		aggregations []string
+		fields       map[string]interface{}
+	}{
+		{
+			name:         "average",
+			aggregations: []string{"Average"},
+			fields:       map[string]interface{}{"az_BlobCapacity": 1024.0},
+		},
+		{
+			name:         "multiple aggregations",
+			aggregations: []string{"Average", "Total"},
+			fields: map[string]interface{}{
+				"az_BlobCapacity_average": 1024.0,
+				"az_BlobCapacity_total":   2048.0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, aggregationsResponse)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.FieldPrefix = "az_"
+			plugin.Aggregations = tt.aggregations
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID},
+					tt.fields,
+					time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+		})
+	}
+}
+
+func TestGatherResourceIDTag(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeResourceIDTag = false
+	plugin.IncludeRegionTag = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"region": "eastus"},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherEmptyResponse(t *testing.T) {
+	ts := newTestServer(t, `{"value": []}`)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Len(t, acc.GetTelegrafMetrics(), 0)
+
+	plugin.ErrorOnEmpty = true
+	require.NoError(t, plugin.Gather(&acc))
+	var emptyErr *AzureMonitorEmptyError
+	require.ErrorAs(t, acc.FirstError(), &emptyErr)
+	require.Equal(t, testResourceID, emptyErr.ResourceID)
+	require.EqualError(t, acc.FirstError(), `azure monitor returned no metrics for resource "`+testResourceID+`"`)
+}
+
+func TestGatherEmitMetadata(t *testing.T) {
+	response := `
+{
+  "namespace": "Microsoft.Storage/storageAccounts",
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "displayDescription": "The amount of storage used by blobs.",
+      "unit": "Bytes",
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}]
+    },
+    {
+      "name": {"value": "Transactions", "localizedValue": "Transactions"},
+      "unit": "Count",
+      "timeseries": []
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.EmitMetadata = true
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor_meta",
+			map[string]string{"resource_id": testResourceID, "metric": "BlobCapacity"},
+			map[string]interface{}{
+				"unit":         "Bytes",
+				"display_name": "Blob Capacity",
+				"namespace":    "Microsoft.Storage/storageAccounts",
+				"description":  "The amount of storage used by blobs.",
+			},
+			now,
+		),
+		testutil.MustMetric(
+			"azure_monitor_meta",
+			map[string]string{"resource_id": testResourceID, "metric": "Transactions"},
+			map[string]interface{}{
+				"unit":         "Count",
+				"display_name": "Transactions",
+				"namespace":    "Microsoft.Storage/storageAccounts",
+			},
+			now,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherResourceOverrides(t *testing.T) {
+	vm := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm"
+	vmResponse := `
+{
+  "value": [
+    {
+      "name": {"value": "Percentage CPU", "localizedValue": "Percentage CPU"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 12.5, "maximum": 40}]}]
+    }
+  ]
+}
+`
+	var mu sync.Mutex
+	queries := make(map[string]url.Values)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries[r.URL.Path] = r.URL.Query()
+		mu.Unlock()
+
+		response := aggregationsResponse
+		if strings.HasPrefix(r.URL.Path, vm) {
+			response = vmResponse
+		}
+		_, err := fmt.Fprint(w, response)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		Metrics:    []string{"BlobCapacity"},
+		Resources: []ResourceConfig{
+			{
+				ResourceID:   vm,
+				Metrics:      []string{"Percentage CPU"},
+				Aggregations: []string{"Average", "Maximum"},
+			},
+		},
+		IncludeResourceIDTag: true,
+		ValuePrecision:       -1,
+		AuthMethod:           "msi",
+		Log:                  testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	require.Equal(t, []string{testResourceID, vm}, plugin.resourceIDs)
+	plugin.baseURL = ts.URL
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	storageQuery := queries[testResourceID+"/providers/microsoft.insights/metrics"]
+	require.Equal(t, "BlobCapacity", storageQuery.Get("metricnames"))
+	require.Equal(t, "Average", storageQuery.Get("aggregation"))
+	vmQuery := queries[vm+"/providers/microsoft.insights/metrics"]
+	require.Equal(t, "Percentage CPU", vmQuery.Get("metricnames"))
+	require.Equal(t, "Average,Maximum", vmQuery.Get("aggregation"))
+
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": vm},
+			map[string]interface{}{
+				"Percentage CPU_average": 12.5,
+				"Percentage CPU_maximum": 40.0,
+			},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherReportUp(t *testing.T) {
+	missing := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/missing"
+	empty := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/empty"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch {
+		case strings.HasPrefix(r.URL.Path, missing):
+			w.WriteHeader(http.StatusNotFound)
+			_, err = fmt.Fprint(w, `{"code":"ResourceNotFound"}`)
+		case strings.HasPrefix(r.URL.Path, empty):
+			_, err = fmt.Fprint(w, `{"value": []}`)
+		default:
+			_, err = fmt.Fprint(w, `{"value": [{"name": {"value": "BlobCapacity"}, "timeseries": []}]}`)
+		}
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.ReportUp = true
+	plugin.ErrorOnEmpty = true
+	plugin.resourceIDs = []string{testResourceID, missing, empty}
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.Len(t, acc.Errors, 2)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("azure_monitor_up", map[string]string{"resource_id": testResourceID}, map[string]interface{}{"up": 1}, now),
+		testutil.MustMetric("azure_monitor_up", map[string]string{"resource_id": missing}, map[string]interface{}{"up": 0}, now),
+		testutil.MustMetric("azure_monitor_up", map[string]string{"resource_id": empty}, map[string]interface{}{"up": 1}, now),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherMetricIDTag(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeMetricIDTag = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{
+				"resource_id": testResourceID,
+				"metric_id":   testResourceID + "/providers/Microsoft.Insights/metrics/BlobCapacity",
+			},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestInitAuthFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "azure.auth")
+	contents := `{
+		"clientId": "00000000-0000-0000-0000-000000000001",
+		"clientSecret": "secret",
+		"tenantId": "00000000-0000-0000-0000-000000000002",
+		"subscriptionId": "00000000-0000-0000-0000-000000000003"
+	}`
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		AuthFile:   path,
+		Log:        testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	require.IsType(t, &tokenAuthorizer{}, plugin.authorizer)
+
+	source := plugin.authorizer.(*tokenAuthorizer).source
+	require.IsType(t, &adal.ServicePrincipalToken{}, source)
+
+	empty := filepath.Join(dir, "empty.auth")
+	require.NoError(t, os.WriteFile(empty, []byte(`{"tenantId": "tenant"}`), 0600))
+
+	plugin = &AzureMonitor{
+		ResourceId: testResourceID,
+		AuthFile:   empty,
+		Log:        testutil.Logger{},
+	}
+	require.EqualError(t, plugin.Init(), fmt.Sprintf("error creating authorizer: auth file %q is missing client and certificate credentials", empty))
+}
+
+func TestInitClientCertificate(t *testing.T) {
+	plugin := &AzureMonitor{
+		ResourceId:         testResourceID,
+		ClientID:           "00000000-0000-0000-0000-000000000001",
+		ClientCertPath:     "testdata/client.pfx",
+		ClientCertPassword: "telegraf",
+		TenantID:           "00000000-0000-0000-0000-000000000002",
+		Log:                testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	require.IsType(t, &adal.ServicePrincipalToken{}, plugin.authorizer.(*tokenAuthorizer).source)
+
+	plugin.ClientCertPassword = "wrong"
+	require.Error(t, plugin.Init())
+}
+
+func TestGatherMaxResponseBodySize(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.MaxResponseBodySize = config.Size(len(aggregationsResponse))
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+
+	plugin.MaxResponseBodySize = config.Size(len(aggregationsResponse) - 1)
+
+	acc = testutil.Accumulator{}
+	require.NoError(t, plugin.Gather(&acc))
+	require.EqualError(t, acc.FirstError(), fmt.Sprintf("response for resource %q exceeds max_response_body_size of %d bytes",
+		testResourceID, len(aggregationsResponse)-1))
+	require.Len(t, acc.GetTelegrafMetrics(), 0)
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		timestamp string
+		expected  time.Time
+	}{
+		{"2021-05-01T00:00:00Z", time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"2021-05-01T00:00:00.1234567Z", time.Date(2021, 5, 1, 0, 0, 0, 123456700, time.UTC)},
+		{"2021-05-01T02:00:00+02:00", time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"2021-05-01T00:00:00.5", time.Date(2021, 5, 1, 0, 0, 0, 500000000, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.timestamp, func(t *testing.T) {
+			actual, err := parseTimestamp(tt.timestamp)
+			require.NoError(t, err)
+			require.True(t, tt.expected.Equal(actual), "expected %s, got %s", tt.expected, actual)
+		})
+	}
+
+	_, err := parseTimestamp("yesterday")
+	require.Error(t, err)
+}
+
+func TestGatherFractionalTimestamp(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [
+        {
+          "data": [
+            {"timeStamp": "2021-05-01T00:00:00.1234567Z", "average": 1024}
+          ]
+        }
+      ]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": float64(1024)},
+			time.Date(2021, 5, 1, 0, 0, 0, 123456700, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherMetricIncludeExclude(t *testing.T) {
+	var values []string
+	for _, metric := range []string{"Transactions", "TransactionsFailed", "Ingress", "Egress"} {
+		values = append(values, fmt.Sprintf(`{
+      "name": {"value": %q, "localizedValue": %q},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1}]}]
+    }`, metric, metric))
+	}
+	response := `{"value": [` + strings.Join(values, ",") + `]}`
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		fields  []string
+	}{
+		{
+			name:   "no patterns",
+			fields: []string{"Egress", "Ingress", "Transactions", "TransactionsFailed"},
+		},
+		{
+			name:    "include",
+			include: []string{"Transactions*"},
+			fields:  []string{"Transactions", "TransactionsFailed"},
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"*gress"},
+			fields:  []string{"Transactions", "TransactionsFailed"},
+		},
+		{
+			name:    "exclude takes precedence",
+			include: []string{"Transactions*", "Ingress"},
+			exclude: []string{"*Failed", "Ingress"},
+			fields:  []string{"Transactions"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := &AzureMonitor{
+				ResourceId:    testResourceID,
+				MetricInclude: tt.include,
+				MetricExclude: tt.exclude,
+				Log:           testutil.Logger{},
+			}
+			require.NoError(t, plugin.Init())
+			plugin.baseURL = ts.URL
+			plugin.authorizer = autorest.NullAuthorizer{}
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			metrics := acc.GetTelegrafMetrics()
+			require.Len(t, metrics, 1)
+			var fields []string
+			for field := range metrics[0].Fields() {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			require.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestGatherMetricExcludeMetadataAndEmpty(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "Ingress", "localizedValue": "Ingress"},
+      "unit": "Bytes",
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1}]}]
+    },
+    {
+      "name": {"value": "Egress", "localizedValue": "Egress"},
+      "unit": "Bytes",
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 2}]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := &AzureMonitor{
+		ResourceId:           testResourceID,
+		MetricExclude:        []string{"Egress"},
+		EmitMetadata:         true,
+		IncludeResourceIDTag: true,
+		Log:                  testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	plugin.baseURL = ts.URL
+	plugin.authorizer = autorest.NullAuthorizer{}
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor_meta",
+			map[string]string{"resource_id": testResourceID, "metric": "Ingress"},
+			map[string]interface{}{
+				"unit":         "Bytes",
+				"display_name": "Ingress",
+			},
+			now,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"Ingress": 1.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+
+	plugin = &AzureMonitor{
+		ResourceId:    testResourceID,
+		MetricExclude: []string{"*gress"},
+		ErrorOnEmpty:  true,
+		Log:           testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	plugin.baseURL = ts.URL
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	acc = testutil.Accumulator{}
+	require.NoError(t, plugin.Gather(&acc))
+	var emptyErr *AzureMonitorEmptyError
+	require.ErrorAs(t, acc.FirstError(), &emptyErr)
+	require.Len(t, acc.GetTelegrafMetrics(), 0)
+}
+
+func TestGatherDimensionsAsFieldSuffix(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "Transactions", "localizedValue": "Transactions"},
+      "timeseries": [
+        {
+          "metadatavalues": [
+            {"name": {"value": "apiname", "localizedValue": "API name"}, "value": "GetBlob"},
+            {"name": {"value": "responsetype", "localizedValue": "Response type"}, "value": "Success"}
+          ],
+          "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 10, "total": 100}]
+        },
+        {
+          "metadatavalues": [
+            {"name": {"value": "responsetype", "localizedValue": "Response type"}, "value": "Success"},
+            {"name": {"value": "apiname", "localizedValue": "API name"}, "value": "PutBlob"}
+          ],
+          "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 20, "total": 200}]
+        },
+        {
+          "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 30, "total": 300}]
+        }
+      ]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Aggregations = []string{"Average", "Total"}
+	plugin.DimensionsAsSuffix = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{
+				"Transactions_GetBlob_Success_average": float64(10),
+				"Transactions_GetBlob_Success_total":   float64(100),
+				"Transactions_PutBlob_Success_average": float64(20),
+				"Transactions_PutBlob_Success_total":   float64(200),
+				"Transactions_average":                 float64(30),
+				"Transactions_total":                   float64(300),
+			},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherUserAgent(t *testing.T) {
+	var userAgent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		Log:        testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	plugin.baseURL = ts.URL
+	plugin.authorizer = autorest.NullAuthorizer{}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.True(t, strings.HasPrefix(userAgent, "Telegraf/"), userAgent)
+
+	plugin.UserAgent = "telegraf-azure-monitor"
+	require.NoError(t, plugin.Gather(&acc))
+	require.Equal(t, "telegraf-azure-monitor", userAgent)
+}
+
+func TestGatherGzip(t *testing.T) {
+	var acceptEncoding string
+	var fail bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		status, body := http.StatusOK, aggregationsResponse
+		if fail {
+			status, body = http.StatusBadRequest, `{"code": "BadRequest"}`
+		}
+		if !strings.Contains(acceptEncoding, "gzip") {
+			w.WriteHeader(status)
+			_, err := fmt.Fprint(w, body)
+			require.NoError(t, err)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(status)
+		gz := gzip.NewWriter(w)
+		_, err := fmt.Fprint(gz, body)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+	}))
+	defer ts.Close()
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(strconv.FormatBool(enabled), func(t *testing.T) {
+			fail = false
+			plugin := &AzureMonitor{
+				ResourceId: testResourceID,
+				EnableGzip: enabled,
+				Log:        testutil.Logger{},
+			}
+			require.NoError(t, plugin.Init())
+			plugin.baseURL = ts.URL
+			plugin.authorizer = autorest.NullAuthorizer{}
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+			require.Len(t, acc.GetTelegrafMetrics(), 1)
+			require.Equal(t, enabled, acceptEncoding == "gzip")
+
+			fail = true
+			acc = testutil.Accumulator{}
+			require.NoError(t, plugin.Gather(&acc))
+			requireMonitorError(t, acc.FirstError(), ts.URL, testResourceID, http.StatusBadRequest, `{"code": "BadRequest"}`)
+		})
+	}
+}
+
+func TestGatherFieldSeparator(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.FieldSeparator = "."
+	plugin.Aggregations = []string{"Average", "Total"}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{
+				"BlobCapacity.average": 1024.0,
+				"BlobCapacity.total":   2048.0,
+			},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherLastSuccess(t *testing.T) {
+	missing := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/missing"
+
+	var fail bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail || strings.HasPrefix(r.URL.Path, missing) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	first := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := first
+	plugin := newTestPlugin(ts.URL)
+	plugin.resourceIDs = []string{testResourceID, missing}
+	plugin.timeFunc = func() time.Time { return now }
+
+	_, ok := plugin.LastSuccess(testResourceID)
+	require.False(t, ok)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+
+	last, ok := plugin.LastSuccess(testResourceID)
+	require.True(t, ok)
+	require.Equal(t, first, last)
+	_, ok = plugin.LastSuccess(missing)
+	require.False(t, ok)
+
+	// A failed collection keeps the time of the last successful one
+	now = first.Add(time.Minute)
+	fail = true
+	require.NoError(t, plugin.Gather(&acc))
+
+	last, ok = plugin.LastSuccess(testResourceID)
+	require.True(t, ok)
+	require.Equal(t, first, last)
+}
+
+func TestGatherCountAsInteger(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "Transactions", "localizedValue": "Transactions"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "total": 30, "count": 2.9999999}]}]
+    }
+  ]
+}
+`
+	tests := []struct {
+		name           string
+		countAsInteger bool
+		count          interface{}
+	}{
+		{name: "integer", countAsInteger: true, count: int64(3)},
+		{name: "float", countAsInteger: false, count: 2.9999999},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.Aggregations = []string{"Total", "Count"}
+			plugin.CountAsInteger = tt.countAsInteger
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			metrics := acc.GetTelegrafMetrics()
+			require.Len(t, metrics, 1)
+			require.Equal(t, map[string]interface{}{
+				"Transactions_total": float64(30),
+				"Transactions_count": tt.count,
+			}, metrics[0].Fields())
+		})
+	}
+}
+
+func TestGatherNextLink(t *testing.T) {
+	var ts *httptest.Server
+	var requests int
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil {
+			page = 1
+		}
+		nextLink := fmt.Sprintf("%s%s/providers/microsoft.insights/metrics?page=%d", ts.URL, testResourceID, page+1)
+		_, err = fmt.Fprintf(w, `{
+  "cost": 1,
+  "value": [{
+    "name": {"value": "Metric%d"},
+    "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": %d}]}]
+  }],
+  "nextLink": %q
+}`, page, page, nextLink)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.ReportCost = true
+	plugin.MaxPages = 3
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, 3, requests)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"Metric1": 1.0, "Metric2": 2.0, "Metric3": 3.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+		testutil.MustMetric(
+			"azure_monitor_cost",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"cost": 3},
+			now,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherNextLinkSplitMetric(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiName, nextLink := "GetBlob", fmt.Sprintf("%s%s/providers/microsoft.insights/metrics?page=2", ts.URL, testResourceID)
+		if r.URL.Query().Get("page") == "2" {
+			apiName, nextLink = "PutBlob", ""
+		}
+		_, err := fmt.Fprintf(w, `{
+  "namespace": "Microsoft.Storage/storageAccounts",
+  "value": [{
+    "id": "%s/providers/Microsoft.Insights/metrics/Transactions",
+    "name": {"value": "Transactions"},
+    "timeseries": [{
+      "metadatavalues": [{"name": {"value": "apiname"}, "value": %q}],
+      "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1}]
+    }]
+  }],
+  "nextLink": %q
+}`, testResourceID, apiName, nextLink)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	// The time series of a metric split across pages are no duplicates
+	for _, policy := range []string{duplicateOverwrite, duplicatePrefix, duplicateError} {
+		t.Run(policy, func(t *testing.T) {
+			plugin := newTestPlugin(ts.URL)
+			plugin.DuplicatePolicy = policy
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID, "apiname": "GetBlob"},
+					map[string]interface{}{"Transactions": 1.0},
+					timestamp,
+				),
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID, "apiname": "PutBlob"},
+					map[string]interface{}{"Transactions": 1.0},
+					timestamp,
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+		})
+	}
+}
+
+func TestGatherNextLinkOtherHost(t *testing.T) {
+	ts := newTestServer(t, `{"value": [], "nextLink": "https://example.com/metrics?page=2"}`)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.EqualError(t, acc.FirstError(), fmt.Sprintf(`refusing to follow next link "https://example.com/metrics?page=2" of resource %q outside of %q`,
+		testResourceID, ts.URL))
+}
+
+func TestGatherValueScale(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "UsedCapacity"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 2000000000, "count": 4}]}]
+    },
+    {
+      "name": {"value": "Transactions"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 10, "count": 4}]}]
+    }
+  ]
+}
+`
+	tests := []struct {
+		name        string
+		valueScale  float64
+		metricScale map[string]float64
+		fields      map[string]interface{}
+	}{
+		{
+			name:       "global",
+			valueScale: 0.5,
+			fields: map[string]interface{}{
+				"UsedCapacity_average": 1e9,
+				"UsedCapacity_count":   int64(4),
+				"Transactions_average": 5.0,
+				"Transactions_count":   int64(4),
+			},
+		},
+		{
+			name:        "per metric overrides global",
+			valueScale:  0.5,
+			metricScale: map[string]float64{"UsedCapacity": 1e-9},
+			fields: map[string]interface{}{
+				"UsedCapacity_average": 2.0,
+				"UsedCapacity_count":   int64(4),
+				"Transactions_average": 5.0,
+				"Transactions_count":   int64(4),
+			},
+		},
+		{
+			name:        "per metric only",
+			metricScale: map[string]float64{"UsedCapacity": 1e-9},
+			fields: map[string]interface{}{
+				"UsedCapacity_average": 2.0,
+				"UsedCapacity_count":   int64(4),
+				"Transactions_average": 10.0,
+				"Transactions_count":   int64(4),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := &AzureMonitor{
+				ResourceId:     testResourceID,
+				Aggregations:   []string{"Average", "Count"},
+				CountAsInteger: true,
+				ValueScale:     tt.valueScale,
+				MetricScale:    tt.metricScale,
+				Log:            testutil.Logger{},
+			}
+			require.NoError(t, plugin.Init())
+			plugin.baseURL = ts.URL
+			plugin.authorizer = autorest.NullAuthorizer{}
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			metrics := acc.GetTelegrafMetrics()
+			require.Len(t, metrics, 1)
+			require.Equal(t, tt.fields, metrics[0].Fields())
+		})
+	}
+}
+
+func TestGatherTimestampShift(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.TimestampShift = config.Duration(time.Minute)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 1, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherEmitErrorCode(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity", "localizedValue": "Blob Capacity"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}],
+      "errorCode": "Success"
+    },
+    {
+      "name": {"value": "BlobCount", "localizedValue": "Blob Count"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 0}]}],
+      "errorCode": "InternalError"
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.EmitErrorCode = true
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+		testutil.MustMetric(
+			"azure_monitor_errors",
+			map[string]string{"resource_id": testResourceID, "metric": "BlobCount"},
+			map[string]interface{}{"error_code": "InternalError", "count": 1},
+			now,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestInitManagementEndpoint(t *testing.T) {
+	plugin := &AzureMonitor{
+		ResourceId:         testResourceID,
+		ManagementEndpoint: "https://westeurope.management.azure.com/",
+		Log:                testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	require.Equal(t, "https://westeurope.management.azure.com", plugin.baseURL)
+}
+
+func TestResourceIDSegment(t *testing.T) {
+	require.Equal(t, "rg", resourceIDSegment(testResourceID, "resourceGroups"))
+	require.Equal(t, "rg", resourceIDSegment(strings.Replace(testResourceID, "resourceGroups", "resourcegroups", 1), "resourceGroups"))
+	require.Equal(t, "00000000-0000-0000-0000-000000000000", resourceIDSegment(testResourceID, "subscriptions"))
+	require.Equal(t, "", resourceIDSegment(testResourceID, "managementGroups"))
+}
+
+func TestGatherResourceIDTags(t *testing.T) {
+	ts := newTestServer(t, aggregationsResponse)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeGroupTag = true
+	plugin.IncludeSubscriptionTag = true
+	plugin.IncludeResourceNameTag = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{
+				"resource_id":     testResourceID,
+				"resource_group":  "rg",
+				"subscription_id": "00000000-0000-0000-0000-000000000000",
+				"resource_name":   "account",
+			},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestInitConnectionPool(t *testing.T) {
+	plugin := &AzureMonitor{
+		ResourceId: testResourceID,
+		Log:        testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	transport := plugin.client.Transport.(*http.Transport)
+	require.Equal(t, 100, transport.MaxIdleConns)
+	require.Equal(t, 10, transport.MaxIdleConnsPerHost)
+
+	plugin = &AzureMonitor{
+		ResourceId:          testResourceID,
+		MaxIdleConns:        20,
+		MaxIdleConnsPerHost: 5,
+		Log:                 testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	transport = plugin.client.Transport.(*http.Transport)
+	require.Equal(t, 20, transport.MaxIdleConns)
+	require.Equal(t, 5, transport.MaxIdleConnsPerHost)
+}
+
+func TestGatherAverageAndCount(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "SuccessE2ELatency"},
+      "timeseries": [
+        {
+          "metadatavalues": [{"name": {"value": "apiname"}, "value": "GetBlob"}],
+          "data": [
+            {"timeStamp": "2021-05-01T00:00:00Z", "average": 12.5, "count": 4},
+            {"timeStamp": "2021-05-01T00:01:00Z", "average": 10, "count": 2},
+            {"timeStamp": "2021-05-01T00:02:00Z", "count": 0}
+          ]
+        }
+      ]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Aggregations = []string{"Average", "Count"}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	tags := map[string]string{"resource_id": testResourceID, "apiname": "GetBlob"}
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			tags,
+			map[string]interface{}{"SuccessE2ELatency_average": 12.5, "SuccessE2ELatency_count": int64(4)},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			tags,
+			map[string]interface{}{"SuccessE2ELatency_average": 10.0, "SuccessE2ELatency_count": int64(2)},
+			time.Date(2021, 5, 1, 0, 1, 0, 0, time.UTC),
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			tags,
+			map[string]interface{}{"SuccessE2ELatency_count": int64(0)},
+			time.Date(2021, 5, 1, 0, 2, 0, 0, time.UTC),
+		),
+	}
+	// The points only differ in their field values and timestamps, which
+	// testutil.SortMetrics does not order reliably
+	actual := acc.GetTelegrafMetrics()
+	sort.Slice(actual, func(i, j int) bool { return actual[i].Time().Before(actual[j].Time()) })
+	testutil.RequireMetricsEqual(t, expected, actual)
+}
+
+func TestGatherWideRows(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity"},
+      "timeseries": [{"data": [
+        {"timeStamp": "2021-05-01T00:00:00Z", "average": 1024},
+        {"timeStamp": "2021-05-01T00:01:00Z", "average": 2048}
+      ]}]
+    },
+    {
+      "name": {"value": "BlobCount"},
+      "timeseries": [{"data": [
+        {"timeStamp": "2021-05-01T00:00:00Z", "average": 10},
+        {"timeStamp": "2021-05-01T00:01:00Z"}
+      ]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 0, 3, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.LatestOnly = true
+	plugin.WideRows = true
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 2048.0, "BlobCount": 10.0},
+			now,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherReportRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-ratelimit-remaining-subscription-reads", "11998")
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.ReportRateLimit = true
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+		testutil.MustMetric(
+			"azure_monitor_internal",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"ratelimit_remaining": int64(11998)},
+			now,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestInitTokenAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		cloud    string
+		audience string
+		expected string
+	}{
+		{name: "default", expected: "https://management.azure.com/"},
+		{name: "cloud", cloud: "AzureChina", expected: "https://management.chinacloudapi.cn/"},
+		{name: "custom", audience: "https://management.core.windows.net/", expected: "https://management.core.windows.net/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &AzureMonitor{
+				ResourceId:    testResourceID,
+				CloudName:     tt.cloud,
+				TokenAudience: tt.audience,
+				ClientID:      "00000000-0000-0000-0000-000000000001",
+				ClientSecret:  "secret",
+				TenantID:      "00000000-0000-0000-0000-000000000002",
+				Log:           testutil.Logger{},
+			}
+			require.NoError(t, plugin.Init())
+			require.Equal(t, tt.expected, plugin.TokenAudience)
+
+			token, err := json.Marshal(plugin.authorizer.(*tokenAuthorizer).source)
+			require.NoError(t, err)
+			var inner struct {
+				Resource string `json:"resource"`
+			}
+			require.NoError(t, json.Unmarshal(token, &inner))
+			require.Equal(t, tt.expected, inner.Resource)
+		})
+	}
+}
+
+func TestGatherFieldNameMap(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "SuccessServerLatency", "localizedValue": "Success Server Latency"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 5}]}]
+    },
+    {
+      "name": {"value": "SuccessE2ELatency", "localizedValue": "Success E2E Latency"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 7}]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.NormalizeFieldNames = true
+	plugin.FieldNameMap = map[string]string{"SuccessServerLatency": "server_latency"}
+	plugin.Aggregations = []string{"Average", "Total"}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{
+				"server_latency_average":      5.0,
+				"success_e2e_latency_average": 7.0,
+			},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherEmptySeriesPolicy(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}]
+    },
+    {
+      "name": {"value": "Transactions"},
+      "timeseries": [{"data": []}]
+    }
+  ]
+}
+`
+	now := time.Date(2021, 5, 1, 0, 3, 0, 0, time.UTC)
+	capacity := testutil.MustMetric(
+		"azure_monitor",
+		map[string]string{"resource_id": testResourceID},
+		map[string]interface{}{"BlobCapacity": 1024.0},
+		time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+	)
+
+	tests := []struct {
+		name     string
+		policy   string
+		expected []telegraf.Metric
+	}{
+		{
+			name:     "skip",
+			policy:   emptySeriesSkip,
+			expected: []telegraf.Metric{capacity},
+		},
+		{
+			name:     "warn",
+			policy:   emptySeriesWarn,
+			expected: []telegraf.Metric{capacity},
+		},
+		{
+			name:   "zero",
+			policy: emptySeriesZero,
+			expected: []telegraf.Metric{
+				capacity,
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID},
+					map[string]interface{}{"Transactions": 0.0},
+					now,
+				),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.EmptySeriesPolicy = tt.policy
+			plugin.timeFunc = func() time.Time { return now }
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			testutil.RequireMetricsEqual(t, tt.expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+		})
+	}
+}
+
+func TestGatherFallbackResourceID(t *testing.T) {
+	fallback := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/secondary"
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, testResourceID+"/") {
+			w.WriteHeader(http.StatusNotFound)
+			_, err := fmt.Fprint(w, `{"code":"ResourceNotFound"}`)
+			require.NoError(t, err)
+			return
+		}
+		_, err := fmt.Fprint(w, aggregationsResponse)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.FallbackResourceId = fallback
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	require.Equal(t, []string{
+		testResourceID + "/providers/microsoft.insights/metrics",
+		fallback + "/providers/microsoft.insights/metrics",
+	}, requested)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": fallback, "failover": "true"},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherFallbackResourceIDFails(t *testing.T) {
+	fallback := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/secondary"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, err := fmt.Fprint(w, `{"code":"ResourceNotFound"}`)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.FallbackResourceId = fallback
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.Len(t, acc.Errors, 1)
+	requireMonitorError(t, acc.FirstError(), ts.URL, fallback, http.StatusNotFound, `{"code":"ResourceNotFound"}`)
+	require.Len(t, acc.GetTelegrafMetrics(), 0)
+}
+
+func TestGatherEmitStatusCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected []telegraf.Metric
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   aggregationsResponse,
+			expected: []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID},
+					map[string]interface{}{"BlobCapacity": 1024.0},
+					time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				),
+				testutil.MustMetric(
+					"azure_monitor_internal",
+					map[string]string{"resource_id": testResourceID},
+					map[string]interface{}{"status_code": int64(200)},
+					time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC),
+				),
+			},
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+			body:   `{"code":"AuthorizationFailed"}`,
+			expected: []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor_internal",
+					map[string]string{"resource_id": testResourceID},
+					map[string]interface{}{"status_code": int64(403)},
+					time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC),
+				),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, err := fmt.Fprint(w, tt.body)
+				require.NoError(t, err)
+			}))
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.EmitStatusCode = true
+			plugin.timeFunc = func() time.Time { return time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			if tt.status != http.StatusOK {
+				requireMonitorError(t, acc.FirstError(), ts.URL, testResourceID, tt.status, tt.body)
+			} else {
+				require.NoError(t, acc.FirstError())
+			}
+
+			testutil.RequireMetricsEqual(t, tt.expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+		})
+	}
+}
+
+func TestGatherValuePrecision(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "SuccessE2ELatency"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 12.34567, "count": 3}]}]
+    }
+  ]
+}
+`
+	tests := []struct {
+		name      string
+		precision int
+		expected  float64
+	}{
+		{name: "no rounding", precision: -1, expected: 12.34567},
+		{name: "integer", precision: 0, expected: 12},
+		{name: "two decimal places", precision: 2, expected: 12.35},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.Aggregations = []string{"Average", "Count"}
+			plugin.ValuePrecision = tt.precision
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID},
+					map[string]interface{}{"SuccessE2ELatency_average": tt.expected, "SuccessE2ELatency_count": int64(3)},
+					time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+		})
+	}
+}
+
+func TestGatherAggregationAsTag(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "Transactions"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1.5, "total": 30, "count": 20}]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Aggregations = []string{"Average", "Total", "Count"}
+	plugin.AggregationAsTag = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "aggregation": "Average"},
+			map[string]interface{}{"Transactions": 1.5},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "aggregation": "Count"},
+			map[string]interface{}{"Transactions": 20.0},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "aggregation": "Total"},
+			map[string]interface{}{"Transactions": 30.0},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestGatherResponseFieldTags(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "id": "` + testResourceID + `/providers/Microsoft.Insights/metrics/BlobCapacity",
+      "type": "Microsoft.Insights/metrics",
+      "name": {"value": "BlobCapacity"},
+      "displayDescription": "The amount of storage used by blobs.",
+      "unit": "Bytes",
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1024}]}]
+    }
+  ]
+}
+`
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "type", value: "Microsoft.Insights/metrics"},
+		{name: "unit", value: "Bytes"},
+		{name: "id", value: testResourceID + "/providers/Microsoft.Insights/metrics/BlobCapacity"},
+		{name: "display_description", value: "The amount of storage used by blobs."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.ResponseFieldTags = []string{tt.name}
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.NoError(t, acc.FirstError())
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID, tt.name: tt.value},
+					map[string]interface{}{"BlobCapacity": 1024.0},
+					time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+		})
+	}
+}
+
+func TestGatherDuplicatePolicy(t *testing.T) {
+	response := `
+{
+  "namespace": "Microsoft.Storage/storageAccounts",
+  "value": [
+    {
+      "id": "` + testResourceID + `/providers/Microsoft.Insights/metrics/Transactions",
+      "name": {"value": "Transactions"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 10}]}]
+    },
+    {
+      "id": "` + testResourceID + `/blobServices/default/providers/Microsoft.Insights/metrics/Transactions",
+      "name": {"value": "Transactions"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 20}]}]
+    }
+  ]
+}
+`
+	tests := []struct {
+		name     string
+		policy   string
+		fields   map[string]interface{}
+		errCount int
+	}{
+		{
+			name:   "overwrite",
+			policy: duplicateOverwrite,
+			fields: map[string]interface{}{"Transactions": 20.0},
+		},
+		{
+			name:   "prefix",
+			policy: duplicatePrefix,
+			fields: map[string]interface{}{
+				"Microsoft_Storage_storageAccounts_Transactions":              10.0,
+				"Microsoft_Storage_storageAccounts_blobServices_Transactions": 20.0,
+			},
+		},
+		{
+			name:     "error",
+			policy:   duplicateError,
+			fields:   map[string]interface{}{"Transactions": 10.0},
+			errCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, response)
+			defer ts.Close()
+
+			plugin := newTestPlugin(ts.URL)
+			plugin.DuplicatePolicy = tt.policy
+
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Gather(&acc))
+			require.Len(t, acc.Errors, tt.errCount)
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"azure_monitor",
+					map[string]string{"resource_id": testResourceID},
+					tt.fields,
+					time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+		})
+	}
+}
+
+func TestValueNamespace(t *testing.T) {
+	require.Equal(t, "Microsoft.Storage/storageAccounts/blobServices",
+		valueNamespace(testResourceID+"/blobServices/default/providers/Microsoft.Insights/metrics/Transactions", "fallback"))
+	require.Equal(t, "Microsoft.Storage/storageAccounts",
+		valueNamespace(testResourceID+"/providers/microsoft.insights/metrics/Transactions", "fallback"))
+	require.Equal(t, "fallback", valueNamespace("", "fallback"))
+	require.Equal(t, "fallback", valueNamespace("/providers/Microsoft.Insights/metrics/Transactions", "fallback"))
+}
+
+func TestGatherNanosecondTimestamps(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity"},
+      "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00.123456789Z", "average": 1024}]}]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	// The timestamps are passed on with nanosecond precision, rounding is left
+	// to the precision of the agent
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 123456789, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherMergesDimensionedSeries(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "Transactions"},
+      "timeseries": [
+        {"metadatavalues": [{"name": {"value": "ApiName"}, "value": "GetBlob"}], "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 10}]},
+        {"metadatavalues": [{"name": {"value": "ApiName"}, "value": "PutBlob"}], "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 20}]}
+      ]
+    },
+    {
+      "name": {"value": "SuccessE2ELatency"},
+      "timeseries": [
+        {"metadatavalues": [{"name": {"value": "ApiName"}, "value": "GetBlob"}], "data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 1.5}]}
+      ]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	// Series of different metrics with the same dimension values and
+	// timestamps are combined into a single point
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "ApiName": "GetBlob"},
+			map[string]interface{}{"Transactions": 10.0, "SuccessE2ELatency": 1.5},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "ApiName": "PutBlob"},
+			map[string]interface{}{"Transactions": 20.0},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func BenchmarkGather(b *testing.B) {
+	start := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	response := AzureMonitorResponse{Namespace: "Microsoft.Storage/storageAccounts"}
+	for i := 0; i < 20; i++ {
+		value := AzureMonitorResponseValue{Name: AzureMonitorResponseValueName{Value: fmt.Sprintf("Metric%d", i)}}
+		for _, apiName := range []string{"GetBlob", "PutBlob", "DeleteBlob", "ListBlobs", "GetBlobProperties"} {
+			series := AzureMonitorResponseTimeSeries{
+				MetadataValues: []AzureMonitorResponseMetadataValue{
+					{Name: AzureMonitorResponseValueName{Value: "ApiName"}, Value: apiName},
+				},
+			}
+			for j := 0; j < 60; j++ {
+				average, total := float64(j), float64(2*j)
+				series.Data = append(series.Data, AzureMonitorResponseTimeSeriesDatum{
+					TimeStamp: start.Add(time.Duration(j) * time.Minute).Format(time.RFC3339),
+					Average:   &average,
+					Total:     &total,
+				})
+			}
+			value.Timeseries = append(value.Timeseries, series)
+		}
+		response.Value = append(response.Value, value)
+	}
+	body, err := json.Marshal(response)
+	require.NoError(b, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Aggregations = []string{"Average", "Total"}
+	acc := testutil.NopAccumulator{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := plugin.Gather(&acc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package azure_monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// circuitBreaker skips resources that failed repeatedly. After threshold
+// consecutive failures a resource is skipped for the cooldown, then requested
+// once more; another failure skips it for the next cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	log       telegraf.Logger
+
+	mu     sync.Mutex
+	states map[string]*breakerState
+}
+
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, log telegraf.Logger) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		log:       log,
+		states:    make(map[string]*breakerState),
+	}
+}
+
+// allow reports whether the resource may be requested at the given time
+func (b *circuitBreaker) allow(resourceID string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.states[resourceID]
+	return !ok || !now.Before(state.openUntil)
+}
+
+// record updates the state of the resource with the outcome of a request
+func (b *circuitBreaker) record(resourceID string, err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.states[resourceID]
+	if err == nil {
+		if ok && state.failures >= b.threshold {
+			b.log.Infof("Circuit breaker for %q closed, resource recovered", resourceID)
+		}
+		delete(b.states, resourceID)
+		return
+	}
+
+	if !ok {
+		state = &breakerState{}
+		b.states[resourceID] = state
+	}
+	state.failures++
+	if state.failures >= b.threshold {
+		state.openUntil = now.Add(b.cooldown)
+		b.log.Infof("Circuit breaker for %q open after %d consecutive failures, skipping it for %s",
+			resourceID, state.failures, b.cooldown)
+	}
+}
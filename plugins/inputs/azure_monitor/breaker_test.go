@@ -0,0 +1,68 @@
+package azure_monitor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(2, time.Minute, testutil.Logger{})
+	failure := errors.New("failure")
+
+	require.True(t, breaker.allow(testResourceID, now))
+	breaker.record(testResourceID, failure, now)
+	require.True(t, breaker.allow(testResourceID, now))
+	breaker.record(testResourceID, failure, now)
+	require.False(t, breaker.allow(testResourceID, now))
+	require.False(t, breaker.allow(testResourceID, now.Add(59*time.Second)))
+
+	// A single attempt is allowed after the cooldown; failing reopens the
+	// breaker right away.
+	now = now.Add(time.Minute)
+	require.True(t, breaker.allow(testResourceID, now))
+	breaker.record(testResourceID, failure, now)
+	require.False(t, breaker.allow(testResourceID, now))
+
+	now = now.Add(time.Minute)
+	require.True(t, breaker.allow(testResourceID, now))
+	breaker.record(testResourceID, nil, now)
+	require.True(t, breaker.allow(testResourceID, now))
+	breaker.record(testResourceID, failure, now)
+	require.True(t, breaker.allow(testResourceID, now))
+}
+
+func TestGatherCircuitBreaker(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		_, err := fmt.Fprint(w, `{"code":"AuthorizationFailed"}`)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.timeFunc = func() time.Time { return now }
+	plugin.breaker = newCircuitBreaker(2, time.Minute, testutil.Logger{})
+
+	for i := 0; i < 4; i++ {
+		var acc testutil.Accumulator
+		require.NoError(t, plugin.Gather(&acc))
+	}
+	require.Equal(t, 2, requests)
+
+	now = now.Add(time.Minute)
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.Error(t, acc.FirstError())
+	require.Equal(t, 3, requests)
+}
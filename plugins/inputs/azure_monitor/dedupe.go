@@ -0,0 +1,62 @@
+package azure_monitor
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+)
+
+// dedupeCache remembers the fields of recently emitted points, so that
+// points Azure returns again in consecutive collections are only written
+// once. The least recently emitted points are evicted once size points are
+// cached.
+type dedupeCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[dedupeKey]*list.Element
+	order   *list.List
+}
+
+type dedupeKey struct {
+	resourceID string
+	bucketKey
+}
+
+type dedupeEntry struct {
+	key    dedupeKey
+	fields map[string]interface{}
+}
+
+func newDedupeCache(size int) *dedupeCache {
+	return &dedupeCache{
+		size:    size,
+		entries: make(map[dedupeKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// seen reports whether the point was already emitted with the same fields,
+// and otherwise remembers it as emitted
+func (c *dedupeCache) seen(key dedupeKey, fields map[string]interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		entry := element.Value.(*dedupeEntry)
+		if reflect.DeepEqual(entry.fields, fields) {
+			return true
+		}
+		entry.fields = fields
+		return false
+	}
+
+	c.entries[key] = c.order.PushFront(&dedupeEntry{key: key, fields: fields})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dedupeEntry).key)
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package azure_monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupeCache(t *testing.T) {
+	timestamp := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	key := func(minute int) dedupeKey {
+		return dedupeKey{
+			resourceID: testResourceID,
+			bucketKey:  bucketKey{measurement: "azure_monitor", timestamp: timestamp.Add(time.Duration(minute) * time.Minute)},
+		}
+	}
+	fields := map[string]interface{}{"BlobCapacity": 1024.0}
+
+	cache := newDedupeCache(2)
+	require.False(t, cache.seen(key(0), fields))
+	require.True(t, cache.seen(key(0), fields))
+
+	// Changed values are emitted again
+	require.False(t, cache.seen(key(0), map[string]interface{}{"BlobCapacity": 2048.0}))
+	require.True(t, cache.seen(key(0), map[string]interface{}{"BlobCapacity": 2048.0}))
+
+	// The least recently emitted point is evicted
+	require.False(t, cache.seen(key(1), fields))
+	require.False(t, cache.seen(key(2), fields))
+	require.False(t, cache.seen(key(0), fields))
+	require.True(t, cache.seen(key(2), fields))
+}
+
+func TestGatherDedupe(t *testing.T) {
+	response := aggregationsResponse
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, response)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.dedupe = newDedupeCache(defaultDedupeCacheSize)
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+
+	// The identical point is suppressed on the second collection
+	acc = testutil.Accumulator{}
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.Len(t, acc.GetTelegrafMetrics(), 0)
+
+	// An updated value of the same point is emitted
+	response = `{"value": [{
+  "name": {"value": "BlobCapacity"},
+  "timeseries": [{"data": [{"timeStamp": "2021-05-01T00:00:00Z", "average": 2048}]}]
+}]}`
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 1)
+	require.Equal(t, map[string]interface{}{"BlobCapacity": 2048.0}, metrics[0].Fields())
+}
@@ -0,0 +1,115 @@
+package azure_monitor
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// AzureMonitorDefinitionsResponse is the body returned by the metric
+// definitions API
+type AzureMonitorDefinitionsResponse struct {
+	Value []AzureMonitorMetricDefinition `json:"value"`
+}
+
+// AzureMonitorMetricDefinition describes a metric a resource exposes
+type AzureMonitorMetricDefinition struct {
+	ResourceID                string                          `json:"-"`
+	Namespace                 string                          `json:"namespace"`
+	Name                      AzureMonitorResponseValueName   `json:"name"`
+	Unit                      string                          `json:"unit"`
+	PrimaryAggregationType    string                          `json:"primaryAggregationType"`
+	SupportedAggregationTypes []string                        `json:"supportedAggregationTypes"`
+	Dimensions                []AzureMonitorResponseValueName `json:"dimensions"`
+}
+
+// ListMetricDefinitions returns the definitions of the metrics exposed by
+// the configured resources, including those listed in resource_id_file, in
+// the configured namespace, such as their names and aggregation types. It
+// helps discovering the values the metrics and aggregations options accept.
+func (a *AzureMonitor) ListMetricDefinitions() ([]AzureMonitorMetricDefinition, error) {
+	resourceIDs, err := a.gatherResourceIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var definitions []AzureMonitorMetricDefinition
+	for _, resourceID := range resourceIDs {
+		query := url.Values{}
+		query.Set("api-version", a.ApiVersion)
+		if a.Namespace != "" {
+			query.Set("metricnamespace", a.Namespace)
+		}
+		requestURL := fmt.Sprintf("%s%s/providers/microsoft.insights/metricDefinitions?%s",
+			a.baseURL, resourceID, query.Encode())
+
+		resp, err := a.requestWithRetries(a.ctx, resourceID, requestURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var definitionsResponse AzureMonitorDefinitionsResponse
+		if err := a.decodeResponse(resp, resourceID, &definitionsResponse); err != nil {
+			return nil, err
+		}
+
+		for _, definition := range definitionsResponse.Value {
+			definition.ResourceID = resourceID
+			definitions = append(definitions, definition)
+		}
+	}
+	return definitions, nil
+}
+
+// unsupportedAggregations returns the requested aggregations the metrics
+// selected by the metrics option do not support, by resource, in the form
+// "<metric> (<aggregation>)". Azure rejects the whole request of a resource
+// for any of them.
+func (a *AzureMonitor) unsupportedAggregations() (map[string][]string, error) {
+	definitions, err := a.ListMetricDefinitions()
+	if err != nil {
+		return nil, err
+	}
+
+	unsupported := make(map[string][]string)
+	for _, definition := range definitions {
+		settings := a.settings(definition.ResourceID)
+		if !containsFold(settings.metrics, definition.Name.Value) {
+			continue
+		}
+		for _, aggregation := range settings.aggregations {
+			if !containsFold(definition.SupportedAggregationTypes, aggregation) {
+				unsupported[definition.ResourceID] = append(unsupported[definition.ResourceID],
+					fmt.Sprintf("%s (%s)", definition.Name.Value, aggregation))
+			}
+		}
+	}
+	return unsupported, nil
+}
+
+// warnUnsupportedAggregations logs a warning for each resource with metrics
+// that do not support the requested aggregations
+func (a *AzureMonitor) warnUnsupportedAggregations() {
+	unsupported, err := a.unsupportedAggregations()
+	if err != nil {
+		a.Log.Warnf("Error requesting metric definitions, aggregations are not validated: %v", err)
+		return
+	}
+	// The file was read by unsupportedAggregations already
+	resourceIDs, _ := a.gatherResourceIDs()
+	for _, resourceID := range resourceIDs {
+		if combinations := unsupported[resourceID]; len(combinations) > 0 {
+			a.Log.Warnf("Metrics of %q do not support the requested aggregations, requests for it will fail: %s",
+				resourceID, strings.Join(combinations, ", "))
+		}
+	}
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
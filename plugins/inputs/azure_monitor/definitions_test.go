@@ -0,0 +1,112 @@
+package azure_monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListMetricDefinitions(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "id": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account/providers/microsoft.insights/metricdefinitions/Transactions",
+      "resourceId": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account",
+      "namespace": "Microsoft.Storage/storageAccounts",
+      "name": {"value": "Transactions", "localizedValue": "Transactions"},
+      "isDimensionRequired": false,
+      "unit": "Count",
+      "primaryAggregationType": "Total",
+      "supportedAggregationTypes": ["Total"],
+      "dimensions": [{"value": "ApiName", "localizedValue": "API Name"}]
+    },
+    {
+      "namespace": "Microsoft.Storage/storageAccounts",
+      "name": {"value": "UsedCapacity", "localizedValue": "Used capacity"},
+      "unit": "Bytes",
+      "primaryAggregationType": "Average",
+      "supportedAggregationTypes": ["Average"]
+    }
+  ]
+}
+`
+	var query url.Values
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		query = r.URL.Query()
+		_, err := fmt.Fprint(w, response)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Namespace = "Microsoft.Storage/storageAccounts"
+
+	definitions, err := plugin.ListMetricDefinitions()
+	require.NoError(t, err)
+	require.Equal(t, []AzureMonitorMetricDefinition{
+		{
+			ResourceID:                testResourceID,
+			Namespace:                 "Microsoft.Storage/storageAccounts",
+			Name:                      AzureMonitorResponseValueName{Value: "Transactions", LocalizedValue: "Transactions"},
+			Unit:                      "Count",
+			PrimaryAggregationType:    "Total",
+			SupportedAggregationTypes: []string{"Total"},
+			Dimensions:                []AzureMonitorResponseValueName{{Value: "ApiName", LocalizedValue: "API Name"}},
+		},
+		{
+			ResourceID:                testResourceID,
+			Namespace:                 "Microsoft.Storage/storageAccounts",
+			Name:                      AzureMonitorResponseValueName{Value: "UsedCapacity", LocalizedValue: "Used capacity"},
+			Unit:                      "Bytes",
+			PrimaryAggregationType:    "Average",
+			SupportedAggregationTypes: []string{"Average"},
+		},
+	}, definitions)
+	require.Equal(t, testResourceID+"/providers/microsoft.insights/metricDefinitions", path)
+	require.Equal(t, defaultAPIVersion, query.Get("api-version"))
+	require.Equal(t, "Microsoft.Storage/storageAccounts", query.Get("metricnamespace"))
+}
+
+func TestUnsupportedAggregations(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "Transactions"},
+      "supportedAggregationTypes": ["Total", "Average", "Minimum", "Maximum"]
+    },
+    {
+      "name": {"value": "UsedCapacity"},
+      "supportedAggregationTypes": ["Average"]
+    },
+    {
+      "name": {"value": "Availability"},
+      "supportedAggregationTypes": ["Average"]
+    }
+  ]
+}
+`
+	ts := newTestServer(t, response)
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.Metrics = []string{"Transactions", "UsedCapacity"}
+	plugin.Aggregations = []string{"Average", "Total"}
+
+	unsupported, err := plugin.unsupportedAggregations()
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{testResourceID: {"UsedCapacity (Total)"}}, unsupported)
+
+	// Only metrics selected by the metrics option are validated
+	plugin.Metrics = nil
+	unsupported, err = plugin.unsupportedAggregations()
+	require.NoError(t, err)
+	require.Empty(t, unsupported)
+}
@@ -0,0 +1,66 @@
+package azure_monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultIMDSURL  = "http://169.254.169.254/metadata/instance/compute/location?api-version=2021-02-01&format=text"
+	imdsTimeout     = 2 * time.Second
+	maxIMDSBodySize = 1024
+)
+
+// fallbackRegion returns the region of the Azure VM Telegraf runs on, using
+// the instance metadata service. It is only requested once; if that fails
+// the fallback is disabled with a warning.
+func (a *AzureMonitor) fallbackRegion(ctx context.Context) string {
+	a.imdsOnce.Do(func() {
+		region, err := a.requestIMDSRegion(ctx)
+		if err != nil {
+			a.Log.Warnf("Error requesting the region from the instance metadata service, region tags of resources without a region are omitted: %v", err)
+			return
+		}
+		a.imdsRegion = region
+	})
+	return a.imdsRegion
+}
+
+func (a *AzureMonitor) requestIMDSRegion(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", a.imdsURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Metadata", "true")
+
+	// The metadata service is link-local and must never be reached through
+	// the configured proxy.
+	client := &http.Client{Transport: &http.Transport{Proxy: nil}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIMDSBodySize))
+	if err != nil {
+		return "", fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata service responded with status %d: %s", resp.StatusCode, body)
+	}
+
+	region := strings.TrimSpace(string(body))
+	if region == "" {
+		return "", errors.New("instance metadata service returned no region")
+	}
+	return region, nil
+}
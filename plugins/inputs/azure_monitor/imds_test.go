@@ -0,0 +1,81 @@
+package azure_monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatherIMDSRegionFallback(t *testing.T) {
+	var imdsRequests int
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		imdsRequests++
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, err := fmt.Fprint(w, "westeurope")
+		require.NoError(t, err)
+	}))
+	defer imds.Close()
+
+	ts := newTestServer(t, strings.Replace(aggregationsResponse, `"eastus"`, `""`, 1))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeRegionTag = true
+	plugin.IMDSRegionFallback = true
+	plugin.imdsURL = imds.URL
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "region": "westeurope"},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	for i := 0; i < 2; i++ {
+		var acc testutil.Accumulator
+		require.NoError(t, plugin.Gather(&acc))
+		require.NoError(t, acc.FirstError())
+		testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+	}
+	require.Equal(t, 1, imdsRequests)
+}
+
+func TestGatherIMDSRegionFallbackError(t *testing.T) {
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer imds.Close()
+
+	ts := newTestServer(t, strings.Replace(aggregationsResponse, `"eastus"`, `""`, 1))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.IncludeRegionTag = true
+	plugin.IMDSRegionFallback = true
+	plugin.imdsURL = imds.URL
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID},
+			map[string]interface{}{"BlobCapacity": 1024.0},
+			time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
@@ -0,0 +1,70 @@
+package azure_monitor
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// The metric namespaces API is only available in a preview version.
+const namespacesAPIVersion = "2017-12-01-preview"
+
+// AzureMonitorNamespacesResponse is the body returned by the metric
+// namespaces API
+type AzureMonitorNamespacesResponse struct {
+	Value []AzureMonitorNamespace `json:"value"`
+}
+
+// AzureMonitorNamespace describes a metric namespace of a resource
+type AzureMonitorNamespace struct {
+	Id             string                          `json:"id"`
+	Name           string                          `json:"name"`
+	Type           string                          `json:"type"`
+	Classification string                          `json:"classification"`
+	Properties     AzureMonitorNamespaceProperties `json:"properties"`
+}
+
+// AzureMonitorNamespaceProperties holds the name of a metric namespace as
+// accepted by the namespace option
+type AzureMonitorNamespaceProperties struct {
+	MetricNamespaceName string `json:"metricNamespaceName"`
+}
+
+// ListNamespaces returns the metric namespaces exposed by the configured
+// resources, including those listed in resource_id_file, in the order they
+// are reported and without duplicates. It helps discovering the values the
+// namespace option accepts.
+func (a *AzureMonitor) ListNamespaces() ([]string, error) {
+	resourceIDs, err := a.gatherResourceIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, resourceID := range resourceIDs {
+		query := url.Values{}
+		query.Set("api-version", namespacesAPIVersion)
+		requestURL := fmt.Sprintf("%s%s/providers/microsoft.insights/metricNamespaces?%s",
+			a.baseURL, resourceID, query.Encode())
+
+		resp, err := a.requestWithRetries(a.ctx, resourceID, requestURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var namespacesResponse AzureMonitorNamespacesResponse
+		if err := a.decodeResponse(resp, resourceID, &namespacesResponse); err != nil {
+			return nil, err
+		}
+
+		for _, namespace := range namespacesResponse.Value {
+			name := namespace.Properties.MetricNamespaceName
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			namespaces = append(namespaces, name)
+		}
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,74 @@
+package azure_monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListNamespaces(t *testing.T) {
+	other := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/other"
+	response := `
+{
+  "value": [
+    {
+      "id": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account/providers/microsoft.insights/metricNamespaces/Microsoft.Storage-storageAccounts",
+      "name": "Microsoft.Storage-storageAccounts",
+      "type": "Microsoft.Insights/metricNamespaces",
+      "classification": "Platform",
+      "properties": {"metricNamespaceName": "Microsoft.Storage/storageAccounts"}
+    },
+    {
+      "name": "Microsoft.Storage-storageAccounts-blobServices",
+      "type": "Microsoft.Insights/metricNamespaces",
+      "classification": "Platform",
+      "properties": {"metricNamespaceName": "Microsoft.Storage/storageAccounts/blobServices"}
+    }
+  ]
+}
+`
+	var paths []string
+	var apiVersions []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		apiVersions = append(apiVersions, r.URL.Query().Get("api-version"))
+		_, err := fmt.Fprint(w, response)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.resourceIDs = []string{testResourceID, other}
+
+	namespaces, err := plugin.ListNamespaces()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"Microsoft.Storage/storageAccounts",
+		"Microsoft.Storage/storageAccounts/blobServices",
+	}, namespaces)
+	require.Equal(t, []string{
+		testResourceID + "/providers/microsoft.insights/metricNamespaces",
+		other + "/providers/microsoft.insights/metricNamespaces",
+	}, paths)
+	require.Equal(t, []string{namespacesAPIVersion, namespacesAPIVersion}, apiVersions)
+}
+
+func TestListNamespacesErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := fmt.Fprint(w, `{"code":"AuthorizationFailed"}`)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	plugin := newTestPlugin(ts.URL)
+
+	_, err := plugin.ListNamespaces()
+	var monitorErr *AzureMonitorError
+	require.ErrorAs(t, err, &monitorErr)
+	require.Equal(t, http.StatusForbidden, monitorErr.StatusCode)
+	require.Equal(t, testResourceID, monitorErr.ResourceID)
+}
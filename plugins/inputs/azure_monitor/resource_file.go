@@ -0,0 +1,101 @@
+package azure_monitor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// resourceIDFile caches the resource IDs listed in resource_id_file, one per
+// line. The file is only read again once its modification time or size
+// changes, so it can be checked on every collection.
+type resourceIDFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	ids     []string
+}
+
+// load re-reads the file if it was modified since it was last read and
+// reports whether it was. The previously read IDs are kept if the file
+// cannot be read or contains an invalid ID.
+func (f *resourceIDFile) load(validate func(string) error) (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if f.ids != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return false, nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	ids := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || strings.HasPrefix(id, "#") {
+			continue
+		}
+		if err := validate(id); err != nil {
+			return false, fmt.Errorf("invalid resource id %q on line %d: %v", id, line, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	f.ids = ids
+	return true, nil
+}
+
+// gatherResourceIDs returns the configured resource IDs followed by those
+// listed in resource_id_file that are not configured already. If the file
+// cannot be loaded the IDs it listed before are used along with the error.
+func (a *AzureMonitor) gatherResourceIDs() ([]string, error) {
+	if a.resourceFile == nil {
+		return a.resourceIDs, nil
+	}
+
+	changed, err := a.resourceFile.load(a.validateSubscriptionResourceID)
+	if err != nil {
+		err = fmt.Errorf("error reading resource_id_file %q: %v", a.ResourceIdFile, err)
+	}
+
+	seen := make(map[string]bool, len(a.resourceIDs))
+	for _, resourceID := range a.resourceIDs {
+		seen[resourceID] = true
+	}
+	resourceIDs := append([]string(nil), a.resourceIDs...)
+	for _, resourceID := range a.resourceFile.ids {
+		if !seen[resourceID] {
+			seen[resourceID] = true
+			resourceIDs = append(resourceIDs, resourceID)
+		}
+	}
+	if changed {
+		a.Log.Infof("Read %d resource IDs from %q, gathering %d resources", len(a.resourceFile.ids), a.ResourceIdFile, len(resourceIDs))
+	}
+	return resourceIDs, err
+}
+
+// validateSubscriptionResourceID checks the resource ID and that it is in
+// the configured subscription, if any
+func (a *AzureMonitor) validateSubscriptionResourceID(id string) error {
+	if err := validateResourceID(id); err != nil {
+		return err
+	}
+	if a.SubscriptionID != "" && !strings.HasPrefix(strings.ToLower(id), "/subscriptions/"+strings.ToLower(a.SubscriptionID)+"/") {
+		return fmt.Errorf("not in subscription %q", a.SubscriptionID)
+	}
+	return nil
+}
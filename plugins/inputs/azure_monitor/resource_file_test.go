@@ -0,0 +1,149 @@
+package azure_monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceIDFileLoad(t *testing.T) {
+	other := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/other"
+	path := filepath.Join(t.TempDir(), "resources.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# storage accounts\n"+testResourceID+"\n\n  "+other+"  \n"), 0600))
+
+	f := &resourceIDFile{path: path}
+	changed, err := f.load(validateResourceID)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, []string{testResourceID, other}, f.ids)
+
+	// Unchanged files are not read again
+	changed, err = f.load(validateResourceID)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// Invalid IDs are reported and the previous IDs kept
+	require.NoError(t, os.WriteFile(path, []byte(testResourceID+"\naccount\n"), 0600))
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, future, future))
+	_, err = f.load(validateResourceID)
+	require.EqualError(t, err, `invalid resource id "account" on line 2: must start with a slash`)
+	require.Equal(t, []string{testResourceID, other}, f.ids)
+
+	require.NoError(t, os.WriteFile(path, []byte(other+"\n"), 0600))
+	changed, err = f.load(validateResourceID)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, []string{other}, f.ids)
+}
+
+func TestInitResourceIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resources.txt")
+	require.NoError(t, os.WriteFile(path, []byte(testResourceID+"\n"), 0600))
+
+	plugin := &AzureMonitor{
+		ResourceIdFile: path,
+		AuthMethod:     "msi",
+		Log:            testutil.Logger{},
+	}
+	require.NoError(t, plugin.Init())
+	require.Empty(t, plugin.resourceIDs)
+	require.Equal(t, []string{testResourceID}, plugin.resourceFile.ids)
+
+	plugin = &AzureMonitor{
+		ResourceIdFile: filepath.Join(t.TempDir(), "missing.txt"),
+		AuthMethod:     "msi",
+		Log:            testutil.Logger{},
+	}
+	err := plugin.Init()
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "error reading resource_id_file"), err.Error())
+}
+
+func TestGatherResourceIDFile(t *testing.T) {
+	other := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/other"
+	var mu sync.Mutex
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, strings.TrimSuffix(r.URL.Path, "/providers/microsoft.insights/metrics"))
+		mu.Unlock()
+		_, _ = w.Write([]byte(aggregationsResponse))
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "resources.txt")
+	require.NoError(t, os.WriteFile(path, []byte(testResourceID+"\n"), 0600))
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.ResourceIdFile = path
+	plugin.resourceFile = &resourceIDFile{path: path}
+
+	gather := func() []string {
+		requested = nil
+		var acc testutil.Accumulator
+		require.NoError(t, plugin.Gather(&acc))
+		require.NoError(t, acc.FirstError())
+		sort.Strings(requested)
+		return requested
+	}
+
+	// Resources listed in the file and configured are only gathered once
+	require.Equal(t, []string{testResourceID}, gather())
+
+	require.NoError(t, os.WriteFile(path, []byte(testResourceID+"\n"+other+"\n"), 0600))
+	require.Equal(t, []string{testResourceID, other}, gather())
+
+	// The previous resources are gathered if the file is unreadable
+	require.NoError(t, os.Remove(path))
+	var acc testutil.Accumulator
+	requested = nil
+	require.NoError(t, plugin.Gather(&acc))
+	require.Error(t, acc.FirstError())
+	sort.Strings(requested)
+	require.Equal(t, []string{testResourceID, other}, requested)
+}
+
+func TestListResourceIDFile(t *testing.T) {
+	other := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/other"
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		_, _ = w.Write([]byte(`{"value": []}`))
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "resources.txt")
+	require.NoError(t, os.WriteFile(path, []byte(other+"\n"), 0600))
+
+	plugin := newTestPlugin(ts.URL)
+	plugin.ResourceIdFile = path
+	plugin.resourceFile = &resourceIDFile{path: path}
+
+	// The resources listed in the file are checked and listed as well
+	require.NoError(t, plugin.TestConnection())
+	_, err := plugin.ListNamespaces()
+	require.NoError(t, err)
+	_, err = plugin.ListMetricDefinitions()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		testResourceID + "/providers/microsoft.insights/metrics",
+		other + "/providers/microsoft.insights/metrics",
+		testResourceID + "/providers/microsoft.insights/metricNamespaces",
+		other + "/providers/microsoft.insights/metricNamespaces",
+		testResourceID + "/providers/microsoft.insights/metricDefinitions",
+		other + "/providers/microsoft.insights/metricDefinitions",
+	}, requested)
+
+	require.NoError(t, os.Remove(path))
+	require.Error(t, plugin.TestConnection())
+}
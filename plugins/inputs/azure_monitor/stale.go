@@ -0,0 +1,72 @@
+package azure_monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// staleCache remembers the points of the last successful collection of each
+// resource, so they can be emitted again while the resource is unavailable.
+// Points are served for up to window after the collection they stem from.
+type staleCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	resources map[string]*staleResource
+}
+
+type staleResource struct {
+	gathered time.Time
+	points   []stalePoint
+}
+
+type stalePoint struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]interface{}
+}
+
+func newStaleCache(window time.Duration) *staleCache {
+	return &staleCache{
+		window:    window,
+		resources: make(map[string]*staleResource),
+	}
+}
+
+// store replaces the remembered points of the resource with those of a
+// collection at the given time
+func (c *staleCache) store(resourceID string, gathered time.Time, points []stalePoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resources[resourceID] = &staleResource{gathered: gathered, points: points}
+}
+
+// serve adds the remembered points of the resource at the given time, tagged
+// stale=true, and reports whether there were any within the window. Points
+// older than the window are forgotten.
+func (c *staleCache) serve(acc telegraf.Accumulator, resourceID string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resource, ok := c.resources[resourceID]
+	if !ok {
+		return false
+	}
+	if now.Sub(resource.gathered) > c.window {
+		delete(c.resources, resourceID)
+		return false
+	}
+
+	for _, point := range resource.points {
+		tags := make(map[string]string, len(point.tags)+1)
+		for k, v := range point.tags {
+			tags[k] = v
+		}
+		tags["stale"] = "true"
+		acc.AddFields(point.measurement, point.fields, tags, now)
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package azure_monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatherServeStale(t *testing.T) {
+	response := `
+{
+  "value": [
+    {
+      "name": {"value": "BlobCapacity"},
+      "timeseries": [{"data": [
+        {"timeStamp": "2021-05-01T00:00:00Z", "average": 1024},
+        {"timeStamp": "2021-05-01T00:01:00Z", "average": 2048}
+      ]}]
+    }
+  ]
+}
+`
+	available := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, err := fmt.Fprint(w, response)
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	plugin := newTestPlugin(ts.URL)
+	plugin.stale = newStaleCache(5 * time.Minute)
+	plugin.timeFunc = func() time.Time { return now }
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Gather(&acc))
+	require.NoError(t, acc.FirstError())
+	require.NotEmpty(t, acc.GetTelegrafMetrics())
+
+	// The latest point is served again while the resource is unavailable
+	available = false
+	now = now.Add(5 * time.Minute)
+	acc.ClearMetrics()
+	require.NoError(t, plugin.Gather(&acc))
+	require.Error(t, acc.FirstError())
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"azure_monitor",
+			map[string]string{"resource_id": testResourceID, "stale": "true"},
+			map[string]interface{}{"BlobCapacity": 2048.0},
+			now,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+
+	// Not after the window elapsed
+	now = now.Add(time.Second)
+	acc.ClearMetrics()
+	require.NoError(t, plugin.Gather(&acc))
+	require.Error(t, acc.FirstError())
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
+
+func TestStaleCacheServe(t *testing.T) {
+	gathered := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache := newStaleCache(time.Minute)
+	tags := map[string]string{"resource_id": testResourceID}
+	cache.store(testResourceID, gathered, []stalePoint{
+		{measurement: "azure_monitor", tags: tags, fields: map[string]interface{}{"BlobCount": int64(10)}},
+	})
+
+	var acc testutil.Accumulator
+	require.False(t, cache.serve(&acc, "other", gathered))
+	require.True(t, cache.serve(&acc, testResourceID, gathered.Add(time.Minute)))
+	require.Equal(t, map[string]string{"resource_id": testResourceID}, tags)
+	require.False(t, cache.serve(&acc, testResourceID, gathered.Add(time.Minute+time.Nanosecond)))
+	require.False(t, cache.serve(&acc, testResourceID, gathered))
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+}